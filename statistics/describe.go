@@ -0,0 +1,167 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statistics
+
+import (
+	"strconv"
+	"unicode"
+
+	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
+)
+
+// dimensionless is the unit of statistics which do not depend on the unit of the values
+const dimensionless = "1"
+
+// statDescriptions describes what each statistic is
+var statDescriptions = map[string]string{
+	count:                  "Number of values",
+	mean:                   "Mean",
+	sum:                    "Sum",
+	median:                 "Median",
+	minimum:                "Minimum",
+	maximum:                "Maximum",
+	rangeval:               "Range between minimum and maximum",
+	variance:               "Population variance",
+	standarddeviation:      "Population standard deviation",
+	mode:                   "Most frequent values",
+	kurtosis:               "Population kurtosis",
+	skewness:               "Population skewness",
+	trimean:                "Trimean",
+	firstquartile:          "First quartile",
+	thirdquartile:          "Third quartile",
+	quartilerange:          "Interquartile range",
+	secondpercentile:       "2nd percentile",
+	ninthpercentile:        "9th percentile",
+	twentyfifthpercentile:  "25th percentile",
+	seventyfifthpercentile: "75th percentile",
+	ninetyfirstpercentile:  "91st percentile",
+	ninetyeighthpercentile: "98th percentile",
+	ninetyninthpercentile:  "99th percentile",
+	ninetyfifthpercentile:  "95th percentile",
+	samplevariance:         "Sample variance",
+	samplestddev:           "Sample standard deviation",
+	sampleskewness:         "Sample skewness (adjusted Fisher-Pearson)",
+	excesskurtosis:         "Sample excess kurtosis",
+	nancount:               "Number of NaN values",
+	infcount:               "Number of infinite values",
+	ewma:                   "Exponentially weighted moving average",
+	ewmvar:                 "Exponentially weighted moving variance",
+	ewmstd:                 "Exponentially weighted moving standard deviation",
+	slope:                  "Slope per second of the least squares regression line",
+	intercept:              "Value at the start of the window of the least squares regression line",
+	r2:                     "Coefficient of determination of the least squares regression line",
+	derivative:             "Change per second between the oldest and the newest values",
+	predict:                "Value predicted by the least squares regression line",
+	forecast:               "Holt-Winters forecast of the next value",
+	forecastlower:          "Lower bound of the prediction interval of the next value",
+	forecastupper:          "Upper bound of the prediction interval of the next value",
+	residual:               "Difference between the last value and its Holt-Winters forecast",
+	zscore:                 "Z-score of the newest value",
+	robustzscore:           "Robust z-score of the newest value, based on the median and the median absolute deviation",
+	mad:                    "Median absolute deviation",
+	trimmedmean:            "Trimmed mean",
+	winsorizedmean:         "Winsorized mean",
+	iqrmean:                "Interquartile mean",
+	isanomaly:              "1 when the robust z-score of the newest value exceeds the anomaly threshold, 0 otherwise",
+}
+
+// statUnits gives the unit of the statistics which do not have the unit of the values
+var statUnits = map[string]func(unit string) string{
+	count:          func(string) string { return dimensionless },
+	kurtosis:       func(string) string { return dimensionless },
+	skewness:       func(string) string { return dimensionless },
+	sampleskewness: func(string) string { return dimensionless },
+	excesskurtosis: func(string) string { return dimensionless },
+	nancount:       func(string) string { return dimensionless },
+	infcount:       func(string) string { return dimensionless },
+	variance:       squared,
+	samplevariance: squared,
+	ewmvar:         squared,
+	r2:             func(string) string { return dimensionless },
+	zscore:         func(string) string { return dimensionless },
+	robustzscore:   func(string) string { return dimensionless },
+	isanomaly:      func(string) string { return dimensionless },
+	slope:          perSecond,
+	derivative:     perSecond,
+}
+
+// perSecond returns the unit of a rate of change
+func perSecond(unit string) string {
+	if unit == "" {
+		return ""
+	}
+	return unit + "/s"
+}
+
+// squared returns the unit of a squared value
+func squared(unit string) string {
+	if unit == "" {
+		return ""
+	}
+	for _, r := range unit {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return "(" + unit + ")^2"
+		}
+	}
+	return unit + "^2"
+}
+
+// statUnit returns the unit of a statistic calculated from values of the given unit
+func statUnit(stat, unit string) string {
+	if f, ok := statUnits[stat]; ok {
+		return f(unit)
+	}
+	return unit
+}
+
+// statDescription returns the description of a statistic calculated from metrics of the given description
+func statDescription(stat, description string) string {
+	desc, ok := statDescriptions[stat]
+	if !ok {
+		if percent, ok := parsePercentileStat(stat); ok {
+			desc = strconv.FormatFloat(percent, 'f', -1, 64) + " percentile"
+		} else {
+			desc = stat
+		}
+	}
+	if description == "" {
+		return desc + " over the window"
+	}
+	return desc + " over the window of: " + description
+}
+
+// describe returns the metric holding the tags, unit, description and version of a statistic
+func (d *dataBuffer) describe(stat string, tags map[string]string) plugin.Metric {
+	return plugin.Metric{
+		Tags:        tags,
+		Unit:        statUnit(stat, d.source.Unit),
+		Description: statDescription(stat, d.source.Description),
+		Version:     d.source.Version,
+	}
+}
+
+// SetSource remembers the tags, unit, description and version of the last metric received
+func (d *dataBuffer) SetSource(m plugin.Metric) {
+	d.source = plugin.Metric{
+		Tags:        m.Tags,
+		Unit:        m.Unit,
+		Description: m.Description,
+		Version:     m.Version,
+	}
+}
@@ -0,0 +1,78 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statistics
+
+import (
+	"math"
+	"time"
+)
+
+// ewm maintains the exponentially weighted moving average and variance of every finite value
+// received by a buffer, independently of its window
+type ewm struct {
+	alpha          float64       // weight of a new value
+	halfLife       time.Duration // when set, the weight of a value halves every halfLife instead of depending on alpha
+	mean, variance float64
+	last           time.Time // timestamp of the last value
+	started        bool
+}
+
+// update accounts for a new value
+func (e *ewm) update(value float64, ts time.Time) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return
+	}
+	if !e.started {
+		e.mean, e.variance, e.last, e.started = value, 0, ts, true
+		return
+	}
+
+	alpha := e.alpha
+	if e.halfLife > 0 {
+		// irregular samples weigh according to the time elapsed since the previous one
+		gap := ts.Sub(e.last)
+		if gap <= 0 {
+			return
+		}
+		alpha = 1 - math.Exp(-math.Ln2*float64(gap)/float64(e.halfLife))
+	}
+	e.last = ts
+
+	// incremental update from Finch, "Incremental calculation of weighted mean and variance" (2009)
+	diff := value - e.mean
+	increment := alpha * diff
+	e.mean += increment
+	e.variance = (1 - alpha) * (e.variance + diff*increment)
+}
+
+// EWMA returns the exponentially weighted moving average, NaN before any value
+func (d *dataBuffer) EWMA() float64 {
+	if !d.ewm.started {
+		return math.NaN()
+	}
+	return d.ewm.mean
+}
+
+// EWMVariance returns the exponentially weighted moving variance, NaN before any value
+func (d *dataBuffer) EWMVariance() float64 {
+	if !d.ewm.started {
+		return math.NaN()
+	}
+	return d.ewm.variance
+}
@@ -0,0 +1,113 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statistics
+
+import "math"
+
+// holt maintains the additive Holt-Winters (triple exponential smoothing) state of every finite value
+// received by a buffer, independently of its window. Without season it is Holt's linear method
+type holt struct {
+	alpha, beta, gamma float64   // smoothing of the level, trend and season
+	deviations         float64   // width of the prediction interval in standard deviations of the residuals
+	level, trend       float64   // state after the last value
+	season             []float64 // seasonal components, empty without season
+	seasonLength       int
+	n                  int     // number of values received
+	residual           float64 // difference between the last value and its forecast
+	mse                float64 // mean squared residual, weighted like the level
+}
+
+// newHolt returns an empty state, seasonal when seasonLength is greater than 1
+func newHolt(alpha, beta, gamma, deviations float64, seasonLength int) holt {
+	h := holt{alpha: alpha, beta: beta, gamma: gamma, deviations: deviations, residual: math.NaN(), mse: math.NaN()}
+	if seasonLength > 1 {
+		h.seasonLength = seasonLength
+		h.season = make([]float64, seasonLength)
+	}
+	return h
+}
+
+// seasonal returns the seasonal component of the n-th value
+func (h *holt) seasonal(n int) float64 {
+	if h.seasonLength == 0 {
+		return 0
+	}
+	return h.season[n%h.seasonLength]
+}
+
+// update accounts for a new value
+func (h *holt) update(value float64) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return
+	}
+	defer func() { h.n++ }()
+
+	// the first season, or the first value without season, initializes the level and the seasonal components
+	if h.n < h.seasonLength || h.n == 0 {
+		if h.seasonLength == 0 {
+			h.level = value
+			return
+		}
+		h.season[h.n] = value
+		if h.n == h.seasonLength-1 {
+			for _, v := range h.season {
+				h.level += v / float64(h.seasonLength)
+			}
+			for i := range h.season {
+				h.season[i] -= h.level
+			}
+		}
+		return
+	}
+
+	s := h.seasonal(h.n)
+	h.residual = value - (h.level + h.trend + s)
+	if math.IsNaN(h.mse) {
+		h.mse = h.residual * h.residual
+	} else {
+		h.mse = h.alpha*h.residual*h.residual + (1-h.alpha)*h.mse
+	}
+
+	level := h.level
+	h.level = h.alpha*(value-s) + (1-h.alpha)*(h.level+h.trend)
+	h.trend = h.beta*(h.level-level) + (1-h.beta)*h.trend
+	if h.seasonLength > 0 {
+		h.season[h.n%h.seasonLength] = h.gamma*(value-h.level) + (1-h.gamma)*s
+	}
+}
+
+// Forecast returns the forecast of the next value, NaN until the state is initialized
+func (d *dataBuffer) Forecast() float64 {
+	h := &d.holt
+	if h.n == 0 || h.n < h.seasonLength {
+		return math.NaN()
+	}
+	return h.level + h.trend + h.seasonal(h.n)
+}
+
+// ForecastInterval returns the bounds of the prediction interval around the forecast
+func (d *dataBuffer) ForecastInterval(forecast float64) (lower, upper float64) {
+	width := d.holt.deviations * math.Sqrt(d.holt.mse)
+	return forecast - width, forecast + width
+}
+
+// Residual returns the difference between the last value and its forecast
+func (d *dataBuffer) Residual() float64 {
+	return d.holt.residual
+}
@@ -21,21 +21,59 @@ package statistics
 import (
 	"fmt"
 	"math"
-	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
 )
 
+// dataBuffer holds the values of a window in arrival order, together with structures
+// updated on every insertion so that statistics never require sorting the window:
+// an order statistic tree for ranks and running sums for the moments
 type dataBuffer struct {
-	data                         []data
-	slidingFactorIndex, old, new int //sliding factor specifies how many data values to include over each sliding window
+	mu                  sync.Mutex         // serializes the tasks feeding the buffer
+	window              ring               // values in arrival order, only the oldest and the newest ones with a sketch
+	n                   int                // number of values in the window
+	sorted              orderStatTree      // values in ascending order, unless quantiles are estimated by a sketch
+	sketch              quantileSketch     // estimates the quantiles instead of the sorted values when set
+	panes               []pane             // values of a sliding window with a sketch by emission, so that they can leave it
+	finiteOnly          bool               // statistics ignore the non finite values, which are only counted
+	sum                 float64            // sum of the finite values
+	mean, m2            float64            // running mean and sum of squared differences from it (Welford) of the finite values
+	removals            int                // values removed since the running totals were last recomputed
+	m3, m4              float64            // running sums of cubed and fourth powers of differences from the mean, with a sketch
+	min, max            float64            // extreme finite values, with a sketch
+	nan, posInf, negInf int                // count of the non finite values in the window
+	capacity            int                // maximum number of values of a count based window
+	slidingFactorIndex  int                //sliding factor specifies how many data values to include over each sliding window
+	duration            time.Duration      // when set, the window holds every data value newer than duration instead of a fixed count
+	latest              time.Time          // newest timestamp received, time based windows end there
+	windowEnd           time.Time          // end of the current tumbling time window
+	method              int                // percentile method, see Quantile
+	members             map[string]*member // namespaces which have values in a window grouping several of them
+	counters            map[string]counter // previous sample of each namespace when values are transformed
+	pruned              time.Time          // last time the counters were pruned by idleTimeout
+	ewm                 ewm                // exponentially weighted moving statistics of every value received
+	horizon             time.Duration      // how far after the newest value the trend is predicted
+	holt                holt               // Holt-Winters forecasting state of every value received
+	threshold           float64            // robust z-score above which a value is an anomaly
+	trim                float64            // fraction of the values at each end left out by the robust means
+	source              plugin.Metric      // tags, unit, description and version of the last metric received
 }
 
 // data holds the timestamp and the value (actual data)
 type data struct {
-	ts    time.Time
-	value float64
+	ts     time.Time
+	value  float64
+	member *member // namespace the value comes from when the window groups several of them
+}
+
+// member counts the values of one namespace in a window grouping several of them
+type member struct {
+	name  string
+	count int
 }
 
 const (
@@ -63,26 +101,323 @@ const (
 	ninetyeighthpercentile = "ninetyeighthpercentile"
 	ninetyninthpercentile  = "ninetyninthpercentile"
 	ninetyfifthpercentile  = "ninetyfifthpercentile"
+	samplevariance         = "samplevariance"
+	samplestddev           = "samplestddev"
+	sampleskewness         = "sampleskewness"
+	excesskurtosis         = "excesskurtosis"
+	nancount               = "nancount"
+	infcount               = "infcount"
+	ewma                   = "ewma"
+	ewmvar                 = "ewmvar"
+	ewmstd                 = "ewmstd"
+	slope                  = "slope"
+	intercept              = "intercept"
+	r2                     = "r2"
+	derivative             = "derivative"
+	predict                = "predict"
+	forecast               = "forecast"
+	forecastlower          = "forecastlower"
+	forecastupper          = "forecastupper"
+	residual               = "residual"
+	zscore                 = "zscore"
+	robustzscore           = "robustzscore"
+	isanomaly              = "isanomaly"
+	mad                    = "mad"
+	trimmedmean            = "trimmedmean"
+	winsorizedmean         = "winsorizedmean"
+	iqrmean                = "iqrmean"
 )
 
 var (
 	statList = []string{count, mean, sum, median, minimum, maximum, rangeval, variance, standarddeviation, mode, kurtosis, skewness, trimean, firstquartile, thirdquartile, quartilerange,
-		secondpercentile, ninthpercentile, twentyfifthpercentile, seventyfifthpercentile, ninetyfirstpercentile, ninetyeighthpercentile, ninetyfifthpercentile, ninetyninthpercentile}
+		secondpercentile, ninthpercentile, twentyfifthpercentile, seventyfifthpercentile, ninetyfirstpercentile, ninetyeighthpercentile, ninetyfifthpercentile, ninetyninthpercentile,
+		samplevariance, samplestddev, sampleskewness, excesskurtosis}
+
+	// valueStats need every value of the window, which are not kept when quantiles are estimated by a sketch
+	valueStats = map[string]bool{mode: true, slope: true, intercept: true, r2: true, predict: true,
+		robustzscore: true, isanomaly: true, mad: true, trimmedmean: true, winsorizedmean: true, iqrmean: true}
 )
 
+// newDataBuffer returns an empty buffer sized according to the window configuration
+func newDataBuffer(c config) *dataBuffer {
+	sketch := newQuantileSketch(c.quantileEngine, c.quantileAccuracy)
+	length := c.slidingWindowLength
+	var panes []pane
+	if sketch != nil {
+		// the sketch stands for the values, only the oldest and the newest ones are kept
+		length = 2
+		if c.windowMode == slidingWindow {
+			panes = []pane{{sketch: sketch.New()}}
+		}
+	}
+	return &dataBuffer{
+		window:     newRing(length),
+		panes:      panes,
+		capacity:   c.slidingWindowLength,
+		duration:   c.windowDuration,
+		method:     c.percentileMethod,
+		sketch:     sketch,
+		finiteOnly: c.nonFiniteInputs == countNonFinite,
+		ewm:        ewm{alpha: c.ewmAlpha, halfLife: c.ewmHalfLife},
+		horizon:    c.predictHorizon,
+		holt:       newHolt(c.forecastAlpha, c.forecastBeta, c.forecastGamma, c.forecastDeviations, c.seasonLength),
+		threshold:  c.anomalyThreshold,
+		trim:       c.trimFraction,
+	}
+}
+
+// percentileStat returns the name of the statistic for a percentile, e.g. p99_9 for the 99.9th percentile
+func percentileStat(percent float64) string {
+	return "p" + strings.Replace(strconv.FormatFloat(percent, 'f', -1, 64), ".", "_", -1)
+}
+
+// parsePercentileStat returns the percentile of a pNN statistic, with "." or "_" as decimal separator
+func parsePercentileStat(stat string) (float64, bool) {
+	if !strings.HasPrefix(stat, "p") {
+		return 0, false
+	}
+	percent, err := strconv.ParseFloat(strings.Replace(stat[1:], "_", ".", -1), 64)
+	if err != nil || !(percent >= 0 && percent <= 100) {
+		return 0, false
+	}
+	return percent, true
+}
+
 func (b *dataBuffer) Insert(value float64, ts time.Time) {
-	// sort by timestamp before inserting
-	sort.Sort(byTimestamp(b.data))
-	if len(b.data) < cap(b.data) {
-		b.data = append(b.data, data{value: value, ts: ts})
-	} else {
+	b.InsertMember(value, ts, "")
+}
+
+// InsertMember inserts a value coming from one of the namespaces grouped in the buffer
+func (b *dataBuffer) InsertMember(value float64, ts time.Time, name string) {
+	if b.n == 0 {
+		b.latest = ts
+		if b.duration > 0 {
+			// tumbling time windows are aligned on multiples of the duration
+			b.windowEnd = ts.Truncate(b.duration).Add(b.duration)
+		}
+	} else if ts.After(b.latest) {
+		b.latest = ts
+	}
+
+	// the member joins before an older value leaves, so that it is not forgotten when it was its only value
+	d := data{value: value, ts: ts, member: b.join(name)}
+	if b.sketch == nil && b.duration == 0 && b.n == b.capacity {
 		// replace older value
-		b.data[0].ts, b.data[0].value = ts, value
+		b.evict()
+	}
+	if b.sketch != nil && b.window.len() == 2 {
+		b.window.replaceNewest(d)
+	} else {
+		b.window.push(d)
+	}
+	b.n++
+	b.add(value)
+	b.ewm.update(value, ts)
+	b.holt.update(value)
+
+	if b.sketch == nil && b.duration > 0 {
+		// time based window drops values which are too old, in arrival order
+		cutoff := b.latest.Add(-b.duration)
+		for b.window.len() > 1 && !b.window.oldest().ts.After(cutoff) {
+			b.evict()
+		}
+	}
+	if len(b.panes) > 0 {
+		b.panes[len(b.panes)-1].insert(d)
+		b.slidePanes()
+	}
+}
+
+// evict removes the oldest value of the window
+func (b *dataBuffer) evict() {
+	d := b.window.pop()
+	b.n--
+	b.remove(d.value)
+	if d.member != nil {
+		d.member.count--
+		if d.member.count == 0 {
+			delete(b.members, d.member.name)
+			delete(b.counters, d.member.name)
+		}
+	}
+}
+
+// join returns the member counting the values of the named namespace, nil when the buffer is not grouped
+func (b *dataBuffer) join(name string) *member {
+	if name == "" {
+		return nil
+	}
+	if b.members == nil {
+		b.members = make(map[string]*member)
+	}
+	m, ok := b.members[name]
+	if !ok {
+		m = &member{name: name}
+		b.members[name] = m
+	}
+	m.count++
+	return m
+}
+
+// add accounts for a value entering the window
+func (b *dataBuffer) add(value float64) {
+	if b.sketch == nil {
+		b.sorted.Insert(value)
+	}
+	switch {
+	case math.IsNaN(value):
+		b.nan++
+	case math.IsInf(value, 1):
+		b.posInf++
+	case math.IsInf(value, -1):
+		b.negInf++
+	default:
+		b.sum += value
+		n := float64(b.finite())
+		delta := value - b.mean
+		deltaN := delta / n
+		term := delta * deltaN * (n - 1)
+		if b.sketch != nil {
+			// the values are not kept, so the higher moments and the extremes are maintained too (Pébay)
+			b.sketch.Add(value)
+			b.m4 += term*deltaN*deltaN*(n*n-3*n+3) + 6*deltaN*deltaN*b.m2 - 4*deltaN*b.m3
+			b.m3 += term*deltaN*(n-2) - 3*deltaN*b.m2
+			if n == 1 || value < b.min {
+				b.min = value
+			}
+			if n == 1 || value > b.max {
+				b.max = value
+			}
+		}
+		b.mean += deltaN
+		b.m2 += term
 	}
 }
 
-func (d *dataBuffer) GetStats(stats []string, ns plugin.Namespace) ([]plugin.Metric, error) {
-	if len(d.data) == 0 {
+// remove accounts for a value leaving the window, which only happens when the values are kept
+func (b *dataBuffer) remove(value float64) {
+	b.sorted.Remove(value)
+	switch {
+	case math.IsNaN(value):
+		b.nan--
+	case math.IsInf(value, 1):
+		b.posInf--
+	case math.IsInf(value, -1):
+		b.negInf--
+	default:
+		n := float64(b.finite())
+		if n == 0 {
+			// start over exactly instead of accumulating rounding errors
+			b.sum, b.mean, b.m2, b.removals = 0, 0, 0, 0
+			return
+		}
+		b.sum -= value
+		delta := value - b.mean
+		b.mean -= delta / n
+		b.m2 -= delta * (value - b.mean)
+		if b.m2 < 0 {
+			b.m2 = 0
+		}
+		b.removals++
+		// removing a value much larger than the remaining ones cancels most of the totals and leaves
+		// its rounding errors behind, recomputing once per window length bounds the slower drift
+		if math.Abs(value) > cancellation*(math.Abs(b.mean)+math.Sqrt(b.m2/n)) || b.removals >= b.window.len() {
+			b.recompute()
+		}
+	}
+}
+
+// cancellation is how many times larger than the remaining finite values a removed value has to be
+// for the running totals to be recomputed
+const cancellation = 1 << 20
+
+// recompute sets the running totals from the finite values of the window
+func (b *dataBuffer) recompute() {
+	b.sum, b.mean, b.m2, b.removals = 0, 0, 0, 0
+	n := 0
+	for i := 0; i < b.window.len(); i++ {
+		if value := b.window.at(i).value; !math.IsNaN(value) && !math.IsInf(value, 0) {
+			b.sum += value
+			n++
+		}
+	}
+	if n == 0 {
+		return
+	}
+	b.mean = b.sum / float64(n)
+	for i := 0; i < b.window.len(); i++ {
+		if value := b.window.at(i).value; !math.IsNaN(value) && !math.IsInf(value, 0) {
+			b.m2 += (value - b.mean) * (value - b.mean)
+		}
+	}
+}
+
+// finite returns the number of finite values in the window
+func (b *dataBuffer) finite() int {
+	return b.n - b.nan - b.posInf - b.negInf
+}
+
+// Full reports whether a count based window holds as many values as it can
+func (b *dataBuffer) Full() bool {
+	return b.duration == 0 && b.n >= b.capacity
+}
+
+// Closed reports whether a value with timestamp ts falls after the end of the current time window
+func (b *dataBuffer) Closed(ts time.Time) bool {
+	return b.duration > 0 && b.n > 0 && !ts.Before(b.windowEnd)
+}
+
+// Reset empties the buffer so that a new window can start
+func (b *dataBuffer) Reset() {
+	if b.n > 0 {
+		// namespaces which sent no sample during the window are gone
+		b.prune(b.window.oldest().ts)
+	}
+	b.window.clear()
+	b.n = 0
+	b.members = nil
+	b.sorted.Clear()
+	if b.sketch != nil {
+		b.sketch.Clear()
+	}
+	if len(b.panes) > 0 {
+		b.panes = []pane{{sketch: b.sketch.New()}}
+	}
+	b.sum, b.mean, b.m2, b.removals = 0, 0, 0, 0
+	b.m3, b.m4 = 0, 0
+	b.nan, b.posInf, b.negInf = 0, 0, 0
+	b.slidingFactorIndex = 0
+	b.latest = time.Time{}
+	b.windowEnd = time.Time{}
+}
+
+// Resize changes the number of values a count based window holds, shrinking drops the oldest values
+// unless they are not kept: a tumbling window then closes with the next value, and the oldest panes
+// of a sliding window leave with it.
+// The sliding factor starts over so that the resized window is emitted with the next value
+func (b *dataBuffer) Resize(capacity int) {
+	if b.sketch == nil {
+		for b.n > capacity {
+			b.evict()
+		}
+		b.window.resize(capacity)
+	}
+	b.capacity = capacity
+	b.slidingFactorIndex = 0
+}
+
+// Retime changes the duration of a time based window, 0 making it count based. Values too old for
+// the new duration leave the window with the next value, and the sliding factor starts over
+func (b *dataBuffer) Retime(duration time.Duration) {
+	b.duration = duration
+	if duration > 0 && b.n > 0 {
+		b.windowEnd = b.latest.Truncate(duration).Add(duration)
+	}
+	b.slidingFactorIndex = 0
+}
+
+func (d *dataBuffer) GetStats(stats []string, ns plugin.Namespace, nan nanOutput) ([]plugin.Metric, error) {
+	if d.n == 0 {
 		return nil, nil
 	}
 	var results []plugin.Metric
@@ -94,9 +429,6 @@ func (d *dataBuffer) GetStats(stats []string, ns plugin.Namespace) ([]plugin.Met
 	// statistics are stored in a map
 	statMap := make(result)
 
-	// sort by value is required before calculating statistics
-	sort.Sort(byValue(d.data))
-
 	// generate config option map
 	opts, err := d.SetConfigOption(stats)
 	if err != nil {
@@ -112,7 +444,7 @@ func (d *dataBuffer) GetStats(stats []string, ns plugin.Namespace) ([]plugin.Met
 		newStat := statMap[stat]
 
 		// create the metric from the statistic we just calculated
-		err = createMetrics(&results, newStat, tags, ns, stat)
+		err = createMetrics(&results, newStat, d.describe(stat, tags), ns, stat, nan)
 		if err != nil {
 			return nil, err
 		}
@@ -121,32 +453,25 @@ func (d *dataBuffer) GetStats(stats []string, ns plugin.Namespace) ([]plugin.Met
 	return results, err
 }
 
-type byValue []data
-
-//functions to sort by value
-func (a byValue) Len() int           { return len(a) }
-func (a byValue) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a byValue) Less(i, j int) bool { return a[i].value < a[j].value }
-
-type byTimestamp []data
-
-//functions to sort by timestamp
-func (a byTimestamp) Len() int           { return len(a) }
-func (a byTimestamp) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a byTimestamp) Less(i, j int) bool { return a[i].ts.After(a[j].ts) }
-
-// Creates a metric for each statistic
-func createMetrics(result *[]plugin.Metric, data interface{}, tags map[string]string, ns plugin.Namespace, metricName string) error {
+// Creates a metric for each statistic, described by meta. Statistics which are not finite follow the nan policy
+func createMetrics(result *[]plugin.Metric, data interface{}, meta plugin.Metric, ns plugin.Namespace, metricName string, nan nanOutput) error {
 	switch data.(type) {
 	case float64:
-		if math.IsNaN(data.(float64)) {
-			return nil
+		if v := data.(float64); math.IsNaN(v) || math.IsInf(v, 0) {
+			switch nan.policy {
+			case emitNaN:
+				// nothing to change
+			case sentinelNaN:
+				data = nan.sentinel
+			default:
+				return nil
+			}
 		}
 	case int:
 		// nothing to change
 	case []float64:
 		for _, val := range data.([]float64) {
-			*result = append(*result, createMetric(val, tags,
+			*result = append(*result, createMetric(val, meta,
 				copyNs(ns).AddStaticElement(metricName).AddDynamicElement("highestfreq", "Gives the highest number of occurences of a data value")))
 		}
 		return nil
@@ -155,16 +480,19 @@ func createMetrics(result *[]plugin.Metric, data interface{}, tags map[string]st
 	}
 
 	namespace := copyNs(ns).AddStaticElement(metricName)
-	*result = append(*result, createMetric(data, tags, namespace))
+	*result = append(*result, createMetric(data, meta, namespace))
 	return nil
 }
 
-func createMetric(data interface{}, tags map[string]string, namespace plugin.Namespace) plugin.Metric {
+func createMetric(data interface{}, meta plugin.Metric, namespace plugin.Namespace) plugin.Metric {
 	return plugin.Metric{
-		Timestamp: time.Now(),
-		Tags:      tags,
-		Data:      data,
-		Namespace: namespace,
+		Timestamp:   time.Now(),
+		Tags:        meta.Tags,
+		Unit:        meta.Unit,
+		Description: meta.Description,
+		Version:     meta.Version,
+		Data:        data,
+		Namespace:   namespace,
 	}
 }
 
@@ -176,9 +504,18 @@ func copyNs(nsIn plugin.Namespace) plugin.Namespace {
 	return nsOut
 }
 
-// Get tags which are start time and stop time
+// Get tags which are the tags of the last metric received, start time and stop time, and the number of namespaces of a grouped window
 func (b *dataBuffer) GetTags() map[string]string {
-	oldTs := b.data[0].ts
-	newTs := b.data[len(b.data)-1].ts
-	return map[string]string{"startTime": oldTs.String(), "stopTime": newTs.String()}
+	oldTs := b.window.oldest().ts
+	newTs := b.window.newest().ts
+	tags := make(map[string]string, len(b.source.Tags)+3)
+	for k, v := range b.source.Tags {
+		tags[k] = v
+	}
+	tags["startTime"], tags["stopTime"] = oldTs.String(), newTs.String()
+	if b.members != nil {
+		// number of namespaces grouped in the window
+		tags["members"] = strconv.Itoa(len(b.members))
+	}
+	return tags
 }
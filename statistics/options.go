@@ -38,6 +38,10 @@ func (d *dataBuffer) SetConfigOption(stats []string) (map[string]statOpt, error)
 			statOpts[standarddeviation] = d.standardDeviationOpt
 		case variance:
 			statOpts[variance] = d.varianceOpt
+		case samplevariance:
+			statOpts[samplevariance] = d.sampleVarianceOpt
+		case samplestddev:
+			statOpts[samplestddev] = d.sampleStdDevOpt
 		case ninetyfifthpercentile:
 			statOpts[ninetyfifthpercentile] = d.ninetyFifthPercentileOpt
 		case ninetyninthpercentile:
@@ -66,6 +70,10 @@ func (d *dataBuffer) SetConfigOption(stats []string) (map[string]statOpt, error)
 			statOpts[kurtosis] = d.kurtosisOpt
 		case skewness:
 			statOpts[skewness] = d.skewnessOpt
+		case sampleskewness:
+			statOpts[sampleskewness] = d.sampleSkewnessOpt
+		case excesskurtosis:
+			statOpts[excesskurtosis] = d.excessKurtosisOpt
 		case sum:
 			statOpts[sum] = d.sumOpt
 		case trimean:
@@ -76,8 +84,55 @@ func (d *dataBuffer) SetConfigOption(stats []string) (map[string]statOpt, error)
 			statOpts[firstquartile] = d.firstQuartileOpt
 		case thirdquartile:
 			statOpts[thirdquartile] = d.thirdQuartileOpt
+		case ewma:
+			statOpts[ewma] = d.ewmaOpt
+		case ewmvar:
+			statOpts[ewmvar] = d.ewmVarOpt
+		case ewmstd:
+			statOpts[ewmstd] = d.ewmStdOpt
+		case slope:
+			statOpts[slope] = d.slopeOpt
+		case intercept:
+			statOpts[intercept] = d.interceptOpt
+		case r2:
+			statOpts[r2] = d.r2Opt
+		case derivative:
+			statOpts[derivative] = d.derivativeOpt
+		case predict:
+			statOpts[predict] = d.predictOpt
+		case forecast:
+			statOpts[forecast] = d.forecastOpt
+		case forecastlower:
+			statOpts[forecastlower] = d.forecastIntervalOpt
+		case forecastupper:
+			statOpts[forecastupper] = d.forecastIntervalOpt
+		case residual:
+			statOpts[residual] = d.residualOpt
+		case mad:
+			statOpts[mad] = d.madOpt
+		case trimmedmean:
+			statOpts[trimmedmean] = d.trimmedMeanOpt
+		case winsorizedmean:
+			statOpts[winsorizedmean] = d.winsorizedMeanOpt
+		case iqrmean:
+			statOpts[iqrmean] = d.iqrMeanOpt
+		case zscore:
+			statOpts[zscore] = d.zscoreOpt
+		case robustzscore:
+			statOpts[robustzscore] = d.robustZscoreOpt
+		case isanomaly:
+			statOpts[isanomaly] = d.isAnomalyOpt
+		case nancount:
+			statOpts[nancount] = d.nanCountOpt
+		case infcount:
+			statOpts[infcount] = d.infCountOpt
 		default:
-			return nil, fmt.Errorf("Unknown statistic received %T:", stat)
+			// any other percentile can be requested as pNN
+			percent, ok := parsePercentileStat(stat)
+			if !ok {
+				return nil, fmt.Errorf("Unknown statistic received: %s", stat)
+			}
+			statOpts[percentileStat(percent)] = d.percentileOpt(percentileStat(percent), percent)
 		}
 	}
 	return statOpts, nil
@@ -89,6 +144,14 @@ func (d *dataBuffer) countOpt(result result) {
 	result[count] = d.Count()
 }
 
+func (d *dataBuffer) nanCountOpt(result result) {
+	result[nancount] = d.nan
+}
+
+func (d *dataBuffer) infCountOpt(result result) {
+	result[infcount] = d.posInf + d.negInf
+}
+
 func (d *dataBuffer) sumOpt(result result) {
 	result[sum] = d.Sum()
 }
@@ -154,11 +217,7 @@ func (d *dataBuffer) quartileRangeOpt(result result) {
 }
 
 func (d *dataBuffer) varianceOpt(result result) {
-	_, ok := result[mean]
-	if !ok {
-		d.meanOpt(result)
-	}
-	result[variance] = d.Variance(result[mean].(float64))
+	result[variance] = d.Variance()
 }
 
 func (d *dataBuffer) standardDeviationOpt(result result) {
@@ -169,36 +228,172 @@ func (d *dataBuffer) standardDeviationOpt(result result) {
 	result[standarddeviation] = d.StandardDeviation(result[variance].(float64))
 }
 
+func (d *dataBuffer) ewmaOpt(result result) {
+	result[ewma] = d.EWMA()
+}
+
+func (d *dataBuffer) ewmVarOpt(result result) {
+	result[ewmvar] = d.EWMVariance()
+}
+
+func (d *dataBuffer) ewmStdOpt(result result) {
+	_, ok := result[ewmvar]
+	if !ok {
+		d.ewmVarOpt(result)
+	}
+	result[ewmstd] = d.StandardDeviation(result[ewmvar].(float64))
+}
+
+func (d *dataBuffer) regressionOpt(result result) {
+	result[slope], result[intercept], result[r2] = d.Regression()
+}
+
+func (d *dataBuffer) slopeOpt(result result) {
+	_, ok := result[slope]
+	if !ok {
+		d.regressionOpt(result)
+	}
+}
+
+func (d *dataBuffer) interceptOpt(result result) {
+	_, ok := result[intercept]
+	if !ok {
+		d.regressionOpt(result)
+	}
+}
+
+func (d *dataBuffer) r2Opt(result result) {
+	_, ok := result[r2]
+	if !ok {
+		d.regressionOpt(result)
+	}
+}
+
+func (d *dataBuffer) derivativeOpt(result result) {
+	result[derivative] = d.Derivative()
+}
+
+func (d *dataBuffer) predictOpt(result result) {
+	_, ok := result[slope]
+	if !ok {
+		d.regressionOpt(result)
+	}
+	result[predict] = d.Predict(result[slope].(float64), result[intercept].(float64))
+}
+
+func (d *dataBuffer) forecastOpt(result result) {
+	result[forecast] = d.Forecast()
+}
+
+func (d *dataBuffer) forecastIntervalOpt(result result) {
+	_, ok := result[forecast]
+	if !ok {
+		d.forecastOpt(result)
+	}
+	result[forecastlower], result[forecastupper] = d.ForecastInterval(result[forecast].(float64))
+}
+
+func (d *dataBuffer) residualOpt(result result) {
+	result[residual] = d.Residual()
+}
+
+func (d *dataBuffer) madOpt(result result) {
+	_, ok := result[median]
+	if !ok {
+		d.medianOpt(result)
+	}
+	result[mad] = d.MAD(result[median].(float64))
+}
+
+func (d *dataBuffer) trimmedMeanOpt(result result) {
+	result[trimmedmean] = d.TrimmedMean()
+}
+
+func (d *dataBuffer) winsorizedMeanOpt(result result) {
+	result[winsorizedmean] = d.WinsorizedMean()
+}
+
+func (d *dataBuffer) iqrMeanOpt(result result) {
+	result[iqrmean] = d.InterQuartileMean()
+}
+
+func (d *dataBuffer) zscoreOpt(result result) {
+	_, ok := result[mean]
+	if !ok {
+		d.meanOpt(result)
+	}
+	_, ok = result[standarddeviation]
+	if !ok {
+		d.standardDeviationOpt(result)
+	}
+	result[zscore] = d.ZScore(result[mean].(float64), result[standarddeviation].(float64))
+}
+
+func (d *dataBuffer) robustZscoreOpt(result result) {
+	_, ok := result[mad]
+	if !ok {
+		d.madOpt(result)
+	}
+	result[robustzscore] = d.RobustZScore(result[median].(float64), result[mad].(float64))
+}
+
+func (d *dataBuffer) isAnomalyOpt(result result) {
+	_, ok := result[robustzscore]
+	if !ok {
+		d.robustZscoreOpt(result)
+	}
+	result[isanomaly] = d.IsAnomaly(result[robustzscore].(float64))
+}
+
+func (d *dataBuffer) sampleVarianceOpt(result result) {
+	result[samplevariance] = d.SampleVariance()
+}
+
+func (d *dataBuffer) sampleStdDevOpt(result result) {
+	_, ok := result[samplevariance]
+	if !ok {
+		d.sampleVarianceOpt(result)
+	}
+	result[samplestddev] = d.StandardDeviation(result[samplevariance].(float64))
+}
+
 func (d *dataBuffer) secondPercentileOpt(result result) {
-	result[secondpercentile], _ = d.PercentileNearestRank(2)
+	result[secondpercentile], _ = d.Percentile(2)
 }
 
 func (d *dataBuffer) ninthPercentileOpt(result result) {
-	result[ninthpercentile], _ = d.PercentileNearestRank(9)
+	result[ninthpercentile], _ = d.Percentile(9)
 }
 
 func (d *dataBuffer) twentyPercentileOpt(result result) {
-	result[twentyfifthpercentile], _ = d.PercentileNearestRank(25)
+	result[twentyfifthpercentile], _ = d.Percentile(25)
 }
 
 func (d *dataBuffer) SeventyFifthPercentileOpt(result result) {
-	result[seventyfifthpercentile], _ = d.PercentileNearestRank(75)
+	result[seventyfifthpercentile], _ = d.Percentile(75)
 }
 
 func (d *dataBuffer) ninetyFirstPercentileOpt(result result) {
-	result[ninetyfirstpercentile], _ = d.PercentileNearestRank(91)
+	result[ninetyfirstpercentile], _ = d.Percentile(91)
 }
 
 func (d *dataBuffer) ninetyFifthPercentileOpt(result result) {
-	result[ninetyfifthpercentile], _ = d.PercentileNearestRank(95)
+	result[ninetyfifthpercentile], _ = d.Percentile(95)
 }
 
 func (d *dataBuffer) ninetyEightPercentileOpt(result result) {
-	result[ninetyeighthpercentile], _ = d.PercentileNearestRank(98)
+	result[ninetyeighthpercentile], _ = d.Percentile(98)
 }
 
 func (d *dataBuffer) ninetyNinthPercentileOpt(result result) {
-	result[ninetyninthpercentile], _ = d.PercentileNearestRank(99)
+	result[ninetyninthpercentile], _ = d.Percentile(99)
+}
+
+// percentileOpt returns the option calculating the given percentile under the stat name
+func (d *dataBuffer) percentileOpt(stat string, percent float64) statOpt {
+	return func(result result) {
+		result[stat], _ = d.Percentile(percent)
+	}
 }
 
 func (d *dataBuffer) skewnessOpt(result result) {
@@ -225,6 +420,22 @@ func (d *dataBuffer) kurtosisOpt(result result) {
 	result[kurtosis] = d.Kurtosis(result[mean].(float64), result[standarddeviation].(float64))
 }
 
+func (d *dataBuffer) sampleSkewnessOpt(result result) {
+	_, ok := result[skewness]
+	if !ok {
+		d.skewnessOpt(result)
+	}
+	result[sampleskewness] = d.SampleSkewness(result[skewness].(float64))
+}
+
+func (d *dataBuffer) excessKurtosisOpt(result result) {
+	_, ok := result[kurtosis]
+	if !ok {
+		d.kurtosisOpt(result)
+	}
+	result[excesskurtosis] = d.ExcessKurtosis(result[kurtosis].(float64))
+}
+
 func (d *dataBuffer) trimeanOpt(result result) {
 	_, ok := result[thirdquartile]
 	if !ok {
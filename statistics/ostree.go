@@ -0,0 +1,168 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statistics
+
+import "math"
+
+// orderStatTree keeps values sorted in a treap where every node knows the size of its subtree,
+// so that inserting, removing and finding the k-th smallest value take O(log n) on average
+type orderStatTree struct {
+	root *treapNode
+	seed uint64
+}
+
+type treapNode struct {
+	value       float64
+	priority    uint64
+	size        int
+	left, right *treapNode
+}
+
+// compareValues orders float64 values, NaN being lower than any other value like in sort.Float64s
+func compareValues(a, b float64) int {
+	switch {
+	case a < b || (math.IsNaN(a) && !math.IsNaN(b)):
+		return -1
+	case a > b || (math.IsNaN(b) && !math.IsNaN(a)):
+		return 1
+	}
+	return 0
+}
+
+func (n *treapNode) sizeOf() int {
+	if n == nil {
+		return 0
+	}
+	return n.size
+}
+
+func (n *treapNode) update() {
+	n.size = 1 + n.left.sizeOf() + n.right.sizeOf()
+}
+
+// Len returns the number of values in the tree
+func (t *orderStatTree) Len() int {
+	return t.root.sizeOf()
+}
+
+// Insert adds a value to the tree
+func (t *orderStatTree) Insert(value float64) {
+	// xorshift is enough to balance the treap and much lighter than a rand.Source per tree
+	if t.seed == 0 {
+		t.seed = 0x9e3779b97f4a7c15
+	}
+	t.seed ^= t.seed << 13
+	t.seed ^= t.seed >> 7
+	t.seed ^= t.seed << 17
+	t.root = t.root.insert(&treapNode{value: value, priority: t.seed, size: 1})
+}
+
+func (n *treapNode) insert(node *treapNode) *treapNode {
+	if n == nil {
+		return node
+	}
+	if compareValues(node.value, n.value) < 0 {
+		n.left = n.left.insert(node)
+		if n.left.priority > n.priority {
+			n = n.rotateRight()
+		}
+	} else {
+		n.right = n.right.insert(node)
+		if n.right.priority > n.priority {
+			n = n.rotateLeft()
+		}
+	}
+	n.update()
+	return n
+}
+
+// Remove removes one occurrence of value from the tree and reports whether it was found
+func (t *orderStatTree) Remove(value float64) bool {
+	var found bool
+	t.root, found = t.root.remove(value)
+	return found
+}
+
+func (n *treapNode) remove(value float64) (*treapNode, bool) {
+	if n == nil {
+		return nil, false
+	}
+	var found bool
+	switch compareValues(value, n.value) {
+	case -1:
+		n.left, found = n.left.remove(value)
+	case 1:
+		n.right, found = n.right.remove(value)
+	default:
+		// sink the node until it has at most one child, then replace it by that child
+		if n.left == nil {
+			return n.right, true
+		} else if n.right == nil {
+			return n.left, true
+		}
+		if n.left.priority > n.right.priority {
+			n = n.rotateRight()
+			n.right, found = n.right.remove(value)
+		} else {
+			n = n.rotateLeft()
+			n.left, found = n.left.remove(value)
+		}
+	}
+	n.update()
+	return n, found
+}
+
+func (n *treapNode) rotateRight() *treapNode {
+	l := n.left
+	n.left, l.right = l.right, n
+	n.update()
+	l.update()
+	return l
+}
+
+func (n *treapNode) rotateLeft() *treapNode {
+	r := n.right
+	n.right, r.left = r.left, n
+	n.update()
+	r.update()
+	return r
+}
+
+// Select returns the k-th smallest value, k starting at 0
+func (t *orderStatTree) Select(k int) float64 {
+	n := t.root
+	for n != nil {
+		l := n.left.sizeOf()
+		switch {
+		case k < l:
+			n = n.left
+		case k == l:
+			return n.value
+		default:
+			k -= l + 1
+			n = n.right
+		}
+	}
+	return math.NaN()
+}
+
+// Clear removes every value
+func (t *orderStatTree) Clear() {
+	t.root = nil
+}
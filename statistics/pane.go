@@ -0,0 +1,147 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statistics
+
+import (
+	"math"
+	"time"
+)
+
+// pane holds the values of a sliding window with a sketch received between two emissions. A sketch cannot
+// forget values, so the values of a pane leave the window together, once none of them is in the window
+type pane struct {
+	sketch              quantileSketch
+	moments             moments         // of the finite values
+	n                   int             // number of values
+	nan, posInf, negInf int             // count of the non finite values
+	oldest              data            // first value received
+	latest              time.Time       // newest timestamp received
+	members             map[*member]int // number of values of each namespace in a window grouping several of them
+}
+
+// insert accounts for a value of the pane
+func (p *pane) insert(d data) {
+	if p.n == 0 || d.ts.After(p.latest) {
+		p.latest = d.ts
+	}
+	if p.n == 0 {
+		p.oldest = d
+	}
+	p.n++
+	if d.member != nil {
+		if p.members == nil {
+			p.members = make(map[*member]int)
+		}
+		p.members[d.member]++
+	}
+	switch {
+	case math.IsNaN(d.value):
+		p.nan++
+	case math.IsInf(d.value, 1):
+		p.posInf++
+	case math.IsInf(d.value, -1):
+		p.negInf++
+	default:
+		p.sketch.Add(d.value)
+		p.moments = p.moments.merge(moments{n: 1, sum: d.value, mean: d.value, min: d.value, max: d.value})
+	}
+}
+
+// moments holds the count, sum, extremes and central moments of a set of finite values
+type moments struct {
+	n                     float64
+	sum, mean, m2, m3, m4 float64 // m2, m3 and m4 are sums of powers of differences from the mean
+	min, max              float64
+}
+
+// merge returns the moments of the union of two sets of values (Pébay, "Formulas for Robust, One-Pass
+// Parallel Computation of Covariances and Arbitrary-Order Statistical Moments")
+func (a moments) merge(b moments) moments {
+	if a.n == 0 {
+		return b
+	}
+	if b.n == 0 {
+		return a
+	}
+	n := a.n + b.n
+	delta := b.mean - a.mean
+	deltaN := delta / n
+	return moments{
+		n:    n,
+		sum:  a.sum + b.sum,
+		mean: a.mean + deltaN*b.n,
+		m2:   a.m2 + b.m2 + delta*deltaN*a.n*b.n,
+		m3:   a.m3 + b.m3 + delta*deltaN*deltaN*a.n*b.n*(a.n-b.n) + 3*deltaN*(a.n*b.m2-b.n*a.m2),
+		m4: a.m4 + b.m4 + delta*deltaN*deltaN*deltaN*a.n*b.n*(a.n*a.n-a.n*b.n+b.n*b.n) +
+			6*deltaN*deltaN*(a.n*a.n*b.m2+b.n*b.n*a.m2) + 4*deltaN*(a.n*b.m3-b.n*a.m3),
+		min: math.Min(a.min, b.min),
+		max: math.Max(a.max, b.max),
+	}
+}
+
+// cut starts a new pane once the statistics of a sliding window with a sketch are emitted
+func (b *dataBuffer) cut() {
+	if len(b.panes) > 0 && b.panes[len(b.panes)-1].n > 0 {
+		b.panes = append(b.panes, pane{sketch: b.sketch.New()})
+	}
+}
+
+// slidePanes removes the oldest panes once none of their values is in the window anymore,
+// and rebuilds the sketch, the moments and the oldest value of the window from the other ones
+func (b *dataBuffer) slidePanes() {
+	left := 0
+	for ; left < len(b.panes)-1; left++ {
+		p := &b.panes[left]
+		if b.duration > 0 && p.latest.After(b.latest.Add(-b.duration)) || b.duration == 0 && b.n-p.n < b.capacity {
+			break
+		}
+		b.n -= p.n
+		b.nan, b.posInf, b.negInf = b.nan-p.nan, b.posInf-p.posInf, b.negInf-p.negInf
+		for m, count := range p.members {
+			m.count -= count
+			if m.count == 0 {
+				delete(b.members, m.name)
+				delete(b.counters, m.name)
+			}
+		}
+	}
+	if left == 0 {
+		return
+	}
+	kept := copy(b.panes, b.panes[left:])
+	for i := kept; i < len(b.panes); i++ {
+		b.panes[i] = pane{}
+	}
+	b.panes = b.panes[:kept]
+
+	var m moments
+	b.sketch.Clear()
+	for i := range b.panes {
+		m = m.merge(b.panes[i].moments)
+		b.sketch.Merge(b.panes[i].sketch)
+	}
+	b.sum, b.mean, b.m2, b.m3, b.m4, b.min, b.max = m.sum, m.mean, m.m2, m.m3, m.m4, m.min, m.max
+
+	newest := b.window.newest()
+	b.window.clear()
+	if b.n > 1 {
+		b.window.push(b.panes[0].oldest)
+	}
+	b.window.push(newest)
+}
@@ -0,0 +1,91 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statistics
+
+// ring holds the data of a window in arrival order, growing when it is full
+type ring struct {
+	entries    []data
+	head, size int
+}
+
+// newRing returns an empty ring able to hold capacity entries before growing
+func newRing(capacity int) ring {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return ring{entries: make([]data, capacity)}
+}
+
+func (r *ring) len() int {
+	return r.size
+}
+
+// push appends d after the newest entry
+func (r *ring) push(d data) {
+	if r.size == len(r.entries) {
+		r.resize(2 * len(r.entries))
+	}
+	r.entries[(r.head+r.size)%len(r.entries)] = d
+	r.size++
+}
+
+// pop removes and returns the oldest entry
+func (r *ring) pop() data {
+	d := r.entries[r.head]
+	r.entries[r.head] = data{}
+	r.head = (r.head + 1) % len(r.entries)
+	r.size--
+	return d
+}
+
+// at returns the i-th oldest entry
+func (r *ring) at(i int) data {
+	return r.entries[(r.head+i)%len(r.entries)]
+}
+
+func (r *ring) oldest() data {
+	return r.at(0)
+}
+
+func (r *ring) newest() data {
+	return r.at(r.size - 1)
+}
+
+// replaceNewest overwrites the newest entry with d
+func (r *ring) replaceNewest(d data) {
+	r.entries[(r.head+r.size-1)%len(r.entries)] = d
+}
+
+// resize reallocates the entries so that the ring can hold capacity entries, oldest first,
+// capacity must not be less than the number of entries
+func (r *ring) resize(capacity int) {
+	entries := make([]data, capacity)
+	for i := 0; i < r.size; i++ {
+		entries[i] = r.at(i)
+	}
+	r.entries, r.head = entries, 0
+}
+
+// clear removes every entry
+func (r *ring) clear() {
+	for i := range r.entries {
+		r.entries[i] = data{}
+	}
+	r.head, r.size = 0, 0
+}
@@ -0,0 +1,272 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statistics
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	// quantile engines
+	exactEngine    = "exact"
+	tdigestEngine  = "tdigest"
+	ddsketchEngine = "ddsketch"
+)
+
+// quantileSketch estimates quantiles of a window in a space which does not depend on the number of values
+type quantileSketch interface {
+	Add(value float64)
+	// Merge adds the values counted by other, a sketch returned by New
+	Merge(other quantileSketch)
+	// Quantile returns an estimation of the q-quantile (0 <= q <= 1), NaN when empty
+	Quantile(q float64) float64
+	Clear()
+	// New returns an empty sketch of the same engine and accuracy
+	New() quantileSketch
+}
+
+// newQuantileSketch returns an empty sketch of the given engine, nil for exact quantiles
+func newQuantileSketch(engine string, accuracy float64) quantileSketch {
+	switch engine {
+	case tdigestEngine:
+		return newTDigest(1 / accuracy)
+	case ddsketchEngine:
+		return newDDSketch(accuracy)
+	}
+	return nil
+}
+
+// tdigest is a merging t-digest (Dunning and Ertl, "Computing Extremely Accurate Quantiles Using t-Digests"),
+// its centroids are small near the tails so that extreme quantiles stay accurate
+type tdigest struct {
+	compression float64
+	centroids   []centroid // sorted by mean
+	pending     []centroid // values and centroids of merged sketches not merged into the centroids yet
+	count       float64
+	min, max    float64
+}
+
+type centroid struct {
+	mean, weight float64
+}
+
+func newTDigest(compression float64) *tdigest {
+	return &tdigest{compression: compression, min: math.Inf(1), max: math.Inf(-1)}
+}
+
+func (t *tdigest) Add(value float64) {
+	t.pending = append(t.pending, centroid{mean: value, weight: 1})
+	t.count++
+	t.min = math.Min(t.min, value)
+	t.max = math.Max(t.max, value)
+	if float64(len(t.pending)) >= 5*t.compression {
+		t.merge()
+	}
+}
+
+func (t *tdigest) Merge(other quantileSketch) {
+	o := other.(*tdigest)
+	t.pending = append(append(t.pending, o.centroids...), o.pending...)
+	t.count += o.count
+	t.min = math.Min(t.min, o.min)
+	t.max = math.Max(t.max, o.max)
+	if float64(len(t.pending)) >= 5*t.compression {
+		t.merge()
+	}
+}
+
+func (t *tdigest) New() quantileSketch {
+	return newTDigest(t.compression)
+}
+
+func (t *tdigest) Clear() {
+	t.centroids, t.pending = t.centroids[:0], t.pending[:0]
+	t.count, t.min, t.max = 0, math.Inf(1), math.Inf(-1)
+}
+
+// scale is the k1 scale function, a centroid may span at most one unit of it
+func (t *tdigest) scale(q float64) float64 {
+	return t.compression / (2 * math.Pi) * math.Asin(2*q-1)
+}
+
+// merge adds the pending centroids to the centroids, merging neighbours as long as the scale function allows it
+func (t *tdigest) merge() {
+	if len(t.pending) == 0 {
+		return
+	}
+	all := make([]centroid, 0, len(t.centroids)+len(t.pending))
+	all = append(append(all, t.centroids...), t.pending...)
+	t.pending = t.pending[:0]
+	sort.Slice(all, func(i, j int) bool { return all[i].mean < all[j].mean })
+
+	merged := all[:1]
+	var before float64 // weight of the centroids before the current one
+	for _, c := range all[1:] {
+		cur := &merged[len(merged)-1]
+		if t.scale((before+cur.weight+c.weight)/t.count)-t.scale(before/t.count) <= 1 {
+			cur.weight += c.weight
+			cur.mean += (c.mean - cur.mean) * c.weight / cur.weight
+		} else {
+			before += cur.weight
+			merged = append(merged, c)
+		}
+	}
+	t.centroids = append(t.centroids[:0], merged...)
+}
+
+func (t *tdigest) Quantile(q float64) float64 {
+	t.merge()
+	if len(t.centroids) == 0 {
+		return math.NaN()
+	}
+	if len(t.centroids) == 1 || q <= 0 {
+		if q >= 1 {
+			return t.max
+		}
+		return math.Max(t.min, math.Min(t.centroids[0].mean, t.max))
+	}
+
+	// each centroid is centered on the middle of the ranks it holds, interpolate between these centers
+	rank := q * t.count
+	first, last := t.centroids[0], t.centroids[len(t.centroids)-1]
+	if rank < first.weight/2 {
+		return t.min + (first.mean-t.min)*rank/(first.weight/2)
+	}
+	if rank >= t.count-last.weight/2 {
+		return last.mean + (t.max-last.mean)*(rank-(t.count-last.weight/2))/(last.weight/2)
+	}
+	cumulated := first.weight / 2
+	for i := 1; i < len(t.centroids); i++ {
+		prev, cur := t.centroids[i-1], t.centroids[i]
+		step := (prev.weight + cur.weight) / 2
+		if rank < cumulated+step {
+			return prev.mean + (cur.mean-prev.mean)*(rank-cumulated)/step
+		}
+		cumulated += step
+	}
+	return last.mean
+}
+
+// ddsketch is a DDSketch (Masson, Rim and Lee, "DDSketch: A Fast and Fully-Mergeable Quantile Sketch
+// with Relative-Error Guarantees"): values are counted in logarithmic buckets, so quantiles have
+// a bounded relative error
+type ddsketch struct {
+	gamma, logGamma float64
+	positive        map[int]int // bucket index of the value to count
+	negative        map[int]int // bucket index of the opposite of the value to count
+	zero, count     int
+}
+
+func newDDSketch(accuracy float64) *ddsketch {
+	gamma := (1 + accuracy) / (1 - accuracy)
+	return &ddsketch{
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+		positive: make(map[int]int),
+		negative: make(map[int]int),
+	}
+}
+
+func (d *ddsketch) index(value float64) int {
+	return int(math.Ceil(math.Log(value) / d.logGamma))
+}
+
+// bucketValue returns the value estimating every value of a bucket within the relative accuracy
+func (d *ddsketch) bucketValue(index int) float64 {
+	return 2 * math.Pow(d.gamma, float64(index)) / (d.gamma + 1)
+}
+
+func (d *ddsketch) Add(value float64) {
+	switch {
+	case value > 0:
+		d.positive[d.index(value)]++
+	case value < 0:
+		d.negative[d.index(-value)]++
+	default:
+		d.zero++
+	}
+	d.count++
+}
+
+func (d *ddsketch) Merge(other quantileSketch) {
+	o := other.(*ddsketch)
+	for i, n := range o.positive {
+		d.positive[i] += n
+	}
+	for i, n := range o.negative {
+		d.negative[i] += n
+	}
+	d.zero += o.zero
+	d.count += o.count
+}
+
+func (d *ddsketch) New() quantileSketch {
+	return &ddsketch{
+		gamma:    d.gamma,
+		logGamma: d.logGamma,
+		positive: make(map[int]int),
+		negative: make(map[int]int),
+	}
+}
+
+func (d *ddsketch) Clear() {
+	d.positive = make(map[int]int)
+	d.negative = make(map[int]int)
+	d.zero, d.count = 0, 0
+}
+
+func (d *ddsketch) Quantile(q float64) float64 {
+	if d.count == 0 {
+		return math.NaN()
+	}
+	rank := q * float64(d.count-1)
+
+	// negative values from the lowest, so from the highest index
+	var cumulated float64
+	indexes := make([]int, 0, len(d.negative))
+	for i := range d.negative {
+		indexes = append(indexes, i)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(indexes)))
+	for _, i := range indexes {
+		cumulated += float64(d.negative[i])
+		if cumulated > rank {
+			return -d.bucketValue(i)
+		}
+	}
+
+	cumulated += float64(d.zero)
+	if cumulated > rank {
+		return 0
+	}
+
+	indexes = indexes[:0]
+	for i := range d.positive {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		cumulated += float64(d.positive[i])
+		if cumulated > rank {
+			return d.bucketValue(i)
+		}
+	}
+	return d.bucketValue(indexes[len(indexes)-1])
+}
@@ -0,0 +1,237 @@
+//
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statistics
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestQuantileSketches(t *testing.T) {
+	percents := []float64{1, 10, 25, 50, 75, 90, 99, 99.9}
+
+	// feed inserts the same values into a buffer for each engine and returns the buffers
+	feed := func(values []float64) map[string]*dataBuffer {
+		buffers := make(map[string]*dataBuffer)
+		for _, engine := range []string{exactEngine, tdigestEngine, ddsketchEngine} {
+			c, err := GetConfig(newConfig())
+			So(err, ShouldBeNil)
+			c.slidingWindowLength = len(values)
+			c.quantileEngine = engine
+			buffers[engine] = newDataBuffer(c)
+		}
+		start := time.Now()
+		for i, v := range values {
+			for _, buffer := range buffers {
+				buffer.Insert(v, start.Add(time.Duration(i)*time.Millisecond))
+			}
+		}
+		return buffers
+	}
+
+	// rank returns the fraction of the window values lower than value, from the buffer keeping them
+	rank := func(buffer *dataBuffer, value float64) float64 {
+		window := make([]float64, buffer.Count())
+		for i := range window {
+			window[i] = buffer.window.at(i).value
+		}
+		sort.Float64s(window)
+		return float64(sort.SearchFloat64s(window, value)) / float64(len(window))
+	}
+
+	// check compares the estimated percentiles with the exact nearest rank percentiles
+	check := func(buffers map[string]*dataBuffer) {
+		for _, percent := range percents {
+			exact, err := buffers[exactEngine].PercentileNearestRank(percent)
+			So(err, ShouldBeNil)
+
+			// t-digest guarantees the rank of its estimations
+			estimated, err := buffers[tdigestEngine].Percentile(percent)
+			So(err, ShouldBeNil)
+			So(rank(buffers[exactEngine], estimated), ShouldAlmostEqual, percent/100, 0.005)
+
+			// DDSketch guarantees the relative error of its estimations
+			estimated, err = buffers[ddsketchEngine].Percentile(percent)
+			So(err, ShouldBeNil)
+			So(math.Abs(estimated-exact)/math.Abs(exact), ShouldBeLessThanOrEqualTo, 0.02)
+		}
+	}
+
+	Convey("Sketches estimate quantiles of large windows", t, func() {
+		rnd := rand.New(rand.NewSource(1))
+		values := make([]float64, 20000)
+		for i := range values {
+			// latency like, long tailed distribution
+			values[i] = math.Exp(rnd.NormFloat64())
+		}
+		buffers := feed(values)
+		check(buffers)
+
+		Convey("The other statistics do not need the values", func() {
+			exact := buffers[exactEngine]
+			for _, engine := range []string{tdigestEngine, ddsketchEngine} {
+				b := buffers[engine]
+				So(b.window.len(), ShouldEqual, 2)
+				So(b.Count(), ShouldEqual, exact.Count())
+				So(b.Minimum(), ShouldEqual, exact.Minimum())
+				So(b.Maximum(), ShouldEqual, exact.Maximum())
+				So(b.Variance(), ShouldAlmostEqual, exact.Variance(), 1e-9)
+				stdev := exact.StandardDeviation(exact.Variance())
+				mean := exact.Mean(exact.Sum(), exact.Count())
+				So(b.Skewness(mean, stdev), ShouldAlmostEqual, exact.Skewness(mean, stdev), 1e-9)
+				So(b.Kurtosis(mean, stdev), ShouldAlmostEqual, exact.Kurtosis(mean, stdev), 1e-9)
+				So(b.Derivative(), ShouldEqual, exact.Derivative())
+			}
+		})
+
+		Convey("Sketches start over with the next window", func() {
+			// the next window holds values which are 1000 times larger
+			for _, b := range buffers {
+				b.Reset()
+			}
+			start := time.Now()
+			for i, v := range values {
+				for _, b := range buffers {
+					b.Insert(v*1000, start.Add(time.Duration(i)*time.Millisecond))
+				}
+			}
+			check(buffers)
+			So(buffers[ddsketchEngine].Minimum(), ShouldEqual, buffers[exactEngine].Minimum())
+		})
+
+		Convey("Negative values and zeros are supported", func() {
+			for i := range values {
+				values[i] = math.Floor(rnd.NormFloat64() * 100)
+			}
+			buffers := feed(values)
+			for _, percent := range percents {
+				exact, _ := buffers[exactEngine].PercentileNearestRank(percent)
+				estimated, _ := buffers[ddsketchEngine].Percentile(percent)
+				So(estimated, ShouldAlmostEqual, exact, math.Abs(exact)*0.02)
+			}
+		})
+	})
+
+	Convey("Sketches slide with the window, one by emission", t, func() {
+		configs := map[string]plugin.Config{}
+		plugins := map[string]*Plugin{}
+		for _, engine := range []string{exactEngine, tdigestEngine, ddsketchEngine} {
+			config := newConfig()
+			config["statistics"] = "count,mean,minimum,maximum,variance,skewness,kurtosis,median,p90"
+			config["slidingWindowLength"] = int64(1000)
+			config["slidingFactor"] = int64(100)
+			config["quantileEngine"] = engine
+			configs[engine], plugins[engine] = config, New()
+		}
+
+		rnd := rand.New(rand.NewSource(1))
+		start := time.Now()
+		emissions := 0
+		for i := 0; i < 5000; i++ {
+			// the values grow, so that the statistics are wrong as soon as old values stay in the window
+			mts := []plugin.Metric{plugin.Metric{
+				Data:      float64(i) + 100*rnd.Float64(),
+				Namespace: plugin.NewNamespace("intel", "psutil", "latency"),
+				Timestamp: start.Add(time.Duration(i) * time.Millisecond),
+			}}
+			stats := map[string]map[string]float64{}
+			for engine, p := range plugins {
+				out, err := p.Process(mts, configs[engine])
+				So(err, ShouldBeNil)
+				stats[engine] = map[string]float64{}
+				for _, m := range out {
+					nsSlice := m.Namespace.Strings()
+					switch v := m.Data.(type) {
+					case int:
+						stats[engine][nsSlice[len(nsSlice)-1]] = float64(v)
+					case float64:
+						stats[engine][nsSlice[len(nsSlice)-1]] = v
+					}
+				}
+			}
+			exact := stats[exactEngine]
+			if len(exact) == 0 {
+				continue
+			}
+			emissions++
+			for _, engine := range []string{tdigestEngine, ddsketchEngine} {
+				estimated := stats[engine]
+				So(len(estimated), ShouldEqual, len(exact))
+				So(estimated[count], ShouldEqual, exact[count])
+				So(estimated[minimum], ShouldEqual, exact[minimum])
+				So(estimated[maximum], ShouldEqual, exact[maximum])
+				So(estimated[mean], ShouldAlmostEqual, exact[mean], 1e-6*exact[mean])
+				So(estimated[variance], ShouldAlmostEqual, exact[variance], 1e-6*exact[variance])
+				So(estimated[skewness], ShouldAlmostEqual, exact[skewness], 1e-6)
+				So(estimated[kurtosis], ShouldAlmostEqual, exact[kurtosis], 1e-6)
+				So(estimated[median], ShouldAlmostEqual, exact[median], 0.02*exact[median])
+				So(estimated["p90"], ShouldAlmostEqual, exact["p90"], 0.02*exact["p90"])
+			}
+		}
+		So(emissions, ShouldEqual, 50)
+	})
+
+	Convey("Time based windows with a sketch drop the values of an emission once they are all too old", t, func() {
+		c, err := GetConfig(newConfig())
+		So(err, ShouldBeNil)
+		c.windowDuration = 10 * time.Second
+		c.quantileEngine = ddsketchEngine
+		buffer := newDataBuffer(c)
+		start := time.Now()
+		insert := func(value float64, seconds int) {
+			buffer.Insert(value, start.Add(time.Duration(seconds)*time.Second))
+		}
+		insert(1, 0)
+		insert(2, 2)
+		buffer.cut()
+		insert(10, 5)
+		buffer.cut()
+		insert(20, 11)
+		So(buffer.Count(), ShouldEqual, 4)
+		So(buffer.Minimum(), ShouldEqual, 1)
+
+		insert(30, 13)
+		So(buffer.Count(), ShouldEqual, 3)
+		So(buffer.Minimum(), ShouldEqual, 10)
+		So(buffer.Mean(buffer.Sum(), buffer.Count()), ShouldAlmostEqual, 20)
+		So(buffer.Median(), ShouldAlmostEqual, 20, 0.4)
+		So(buffer.GetTags()["startTime"], ShouldEqual, start.Add(5*time.Second).String())
+	})
+
+	Convey("Statistics needing the values are rejected with sketches", t, func() {
+		config := newConfig()
+		config["quantileEngine"] = tdigestEngine
+		config["statistics"] = "count,median,p99"
+		_, err := GetConfig(config)
+		So(err, ShouldBeNil)
+
+		config["statistics"] = "count,mad"
+		_, err = GetConfig(config)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,254 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statistics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A state file starts with stateMagic and the format version, followed by the length of the
+// gob encoded snapshot, the snapshot itself and the CRC-32 of the snapshot
+const (
+	stateMagic   = "SNAPSTAT"
+	stateVersion = 3
+)
+
+// snapshot holds the windows of the buffers of the tasks having Identity
+type snapshot struct {
+	Identity string
+	Buffers  map[string]bufferState
+}
+
+// bufferState holds what is needed to rebuild a dataBuffer
+type bufferState struct {
+	Values             []float64
+	Timestamps         []time.Time
+	Members            []string
+	SlidingFactorIndex int
+	Capacity           int
+	Duration           time.Duration
+	Latest             time.Time
+	WindowEnd          time.Time
+	Method             int
+	FiniteOnly         bool
+	Counters           map[string]counterState
+	EWM                ewmState
+	Horizon            time.Duration
+	Holt               holtState
+	Threshold          float64
+	Trim               float64
+	Tags               map[string]string
+	Unit               string
+	Description        string
+	Version            int64
+}
+
+// counterState holds the previous sample of a transformed namespace
+type counterState struct {
+	Value float64
+	Ts    time.Time
+}
+
+// ewmState holds the exponentially weighted moving statistics of a buffer
+type ewmState struct {
+	Alpha          float64
+	HalfLife       time.Duration
+	Mean, Variance float64
+	Last           time.Time
+	Started        bool
+}
+
+// holtState holds the Holt-Winters forecasting state of a buffer
+type holtState struct {
+	Alpha, Beta, Gamma float64
+	Deviations         float64
+	Level, Trend       float64
+	Season             []float64
+	SeasonLength       int
+	N                  int
+	Residual, MSE      float64
+}
+
+// state returns the state of the buffer
+func (b *dataBuffer) state() bufferState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := bufferState{
+		Values:             make([]float64, b.window.len()),
+		Timestamps:         make([]time.Time, b.window.len()),
+		Members:            make([]string, b.window.len()),
+		SlidingFactorIndex: b.slidingFactorIndex,
+		Capacity:           b.capacity,
+		Duration:           b.duration,
+		Latest:             b.latest,
+		WindowEnd:          b.windowEnd,
+		Method:             b.method,
+		FiniteOnly:         b.finiteOnly,
+		Horizon:            b.horizon,
+		Threshold:          b.threshold,
+		Trim:               b.trim,
+		Holt: holtState{b.holt.alpha, b.holt.beta, b.holt.gamma, b.holt.deviations, b.holt.level, b.holt.trend,
+			append([]float64(nil), b.holt.season...), b.holt.seasonLength, b.holt.n, b.holt.residual, b.holt.mse},
+		EWM:         ewmState{b.ewm.alpha, b.ewm.halfLife, b.ewm.mean, b.ewm.variance, b.ewm.last, b.ewm.started},
+		Tags:        b.source.Tags,
+		Unit:        b.source.Unit,
+		Description: b.source.Description,
+		Version:     b.source.Version,
+	}
+	if b.counters != nil {
+		s.Counters = make(map[string]counterState, len(b.counters))
+		for name, c := range b.counters {
+			s.Counters[name] = counterState{Value: c.value, Ts: c.ts}
+		}
+	}
+	for i := range s.Values {
+		d := b.window.at(i)
+		s.Values[i], s.Timestamps[i] = d.value, d.ts
+		if d.member != nil {
+			s.Members[i] = d.member.name
+		}
+	}
+	return s
+}
+
+// restoreBuffer rebuilds a buffer from its state
+func restoreBuffer(s bufferState) *dataBuffer {
+	inputs := keepNonFinite
+	if s.FiniteOnly {
+		inputs = countNonFinite
+	}
+	b := newDataBuffer(config{
+		slidingWindowLength: s.Capacity,
+		windowDuration:      s.Duration,
+		percentileMethod:    s.Method,
+		nonFiniteInputs:     inputs,
+		predictHorizon:      s.Horizon,
+		anomalyThreshold:    s.Threshold,
+		trimFraction:        s.Trim,
+	})
+	for i, value := range s.Values {
+		b.InsertMember(value, s.Timestamps[i], s.Members[i])
+	}
+	for name, c := range s.Counters {
+		if b.counters == nil {
+			b.counters = make(map[string]counter, len(s.Counters))
+		}
+		b.counters[name] = counter{value: c.Value, ts: c.Ts}
+	}
+	// the moving statistics account for more values than the window holds
+	b.ewm = ewm{s.EWM.Alpha, s.EWM.HalfLife, s.EWM.Mean, s.EWM.Variance, s.EWM.Last, s.EWM.Started}
+	b.holt = holt{s.Holt.Alpha, s.Holt.Beta, s.Holt.Gamma, s.Holt.Deviations, s.Holt.Level, s.Holt.Trend,
+		s.Holt.Season, s.Holt.SeasonLength, s.Holt.N, s.Holt.Residual, s.Holt.MSE}
+	b.slidingFactorIndex = s.SlidingFactorIndex
+	b.latest, b.windowEnd = s.Latest, s.WindowEnd
+	b.source.Tags, b.source.Unit, b.source.Description, b.source.Version = s.Tags, s.Unit, s.Description, s.Version
+	return b
+}
+
+// newSnapshot returns the state of the buffers of the tasks having identity
+func newSnapshot(identity string, buffers map[seriesKey]*dataBuffer) snapshot {
+	snap := snapshot{Identity: identity, Buffers: make(map[string]bufferState, len(buffers))}
+	for key, b := range buffers {
+		if b.sketch != nil {
+			// the values of the window are not kept, it starts over after a restart
+			continue
+		}
+		snap.Buffers[string(key)] = b.state()
+	}
+	return snap
+}
+
+// saveState writes the snapshot to the state file, replacing it atomically
+func saveState(path string, snap snapshot) error {
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(snap); err != nil {
+		return err
+	}
+
+	var file bytes.Buffer
+	file.WriteString(stateMagic)
+	binary.Write(&file, binary.BigEndian, uint32(stateVersion))
+	binary.Write(&file, binary.BigEndian, uint64(payload.Len()))
+	file.Write(payload.Bytes())
+	binary.Write(&file, binary.BigEndian, crc32.ChecksumIEEE(payload.Bytes()))
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(file.Bytes()); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// loadState reads the buffers from the state file, which must have been saved by a task having identity
+func loadState(path, identity string) (map[seriesKey]*dataBuffer, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	header := len(stateMagic) + 4 + 8
+	if len(content) < header+4 || string(content[:len(stateMagic)]) != stateMagic {
+		return nil, fmt.Errorf("%s is not a state file", path)
+	}
+	if version := binary.BigEndian.Uint32(content[len(stateMagic):]); version != stateVersion {
+		return nil, fmt.Errorf("unsupported version %d of state file %s", version, path)
+	}
+	length := binary.BigEndian.Uint64(content[len(stateMagic)+4:])
+	if length != uint64(len(content)-header-4) {
+		return nil, fmt.Errorf("state file %s is truncated", path)
+	}
+	payload := content[header : header+int(length)]
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(content[header+int(length):]) {
+		return nil, fmt.Errorf("state file %s is corrupted", path)
+	}
+
+	var snap snapshot
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("state file %s is corrupted: %v", path, err)
+	}
+	if snap.Identity != identity {
+		return nil, fmt.Errorf("state file %s was saved by a task with other options", path)
+	}
+	buffers := make(map[seriesKey]*dataBuffer, len(snap.Buffers))
+	for key, s := range snap.Buffers {
+		if len(s.Timestamps) != len(s.Values) || len(s.Members) != len(s.Values) || (s.Capacity < 1 && s.Duration <= 0) ||
+			len(s.Holt.Season) != s.Holt.SeasonLength {
+			return nil, fmt.Errorf("state file %s is corrupted: invalid buffer %q", path, key)
+		}
+		buffers[seriesKey(key)] = restoreBuffer(s)
+	}
+	return buffers, nil
+}
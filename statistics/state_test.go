@@ -0,0 +1,162 @@
+//
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statistics
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestState(t *testing.T) {
+	Convey("Buffers are saved to the state file and restored", t, func() {
+		dir, err := ioutil.TempDir("", "statistics")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "state")
+
+		config := newConfig()
+		config["statistics"] = sum + "," + count
+		config["slidingWindowLength"] = int64(3)
+		config["slidingFactor"] = int64(2)
+		config["stateFile"] = path
+		config["stateInterval"] = "0s"
+		c, err := GetConfig(config)
+		So(err, ShouldBeNil)
+		identity := c.identity()
+
+		start := time.Now()
+		process := func(statisticsObj *Plugin, values ...float64) (stats []plugin.Metric) {
+			for _, value := range values {
+				mts := []plugin.Metric{plugin.Metric{
+					Data:      value,
+					Namespace: plugin.NewNamespace("intel", "psutil", "load", "load1"),
+					Tags:      map[string]string{"plugin_running_on": "host1"},
+					Timestamp: start,
+				}}
+				start = start.Add(time.Second)
+				mts, err := statisticsObj.Process(mts, config)
+				So(err, ShouldBeNil)
+				stats = append(stats, mts...)
+			}
+			return
+		}
+
+		stats := process(New(), 1, 2, 3, 4)
+		So(len(stats), ShouldEqual, 4)
+
+		// the window holds 2, 3 and 4, the next value is emitted
+		stats = process(New(), 5)
+		So(len(stats), ShouldEqual, 2)
+		for _, m := range stats {
+			nsSlice := m.Namespace.Strings()
+			switch nsSlice[len(nsSlice)-1] {
+			case sum:
+				So(m.Data, ShouldEqual, 12)
+			case count:
+				So(m.Data, ShouldEqual, 3)
+			}
+			So(m.Tags["plugin_running_on"], ShouldEqual, "host1")
+		}
+
+		_, err = loadState(path, identity)
+		So(err, ShouldBeNil)
+
+		Convey("Only the windows of the task are saved", func() {
+			other := newConfig()
+			other["statistics"] = sum
+			statisticsObj := New()
+			mts := []plugin.Metric{plugin.Metric{
+				Data:      float64(1),
+				Namespace: plugin.NewNamespace("intel", "psutil", "load", "load5"),
+				Timestamp: start,
+			}}
+			_, err := statisticsObj.Process(mts, other)
+			So(err, ShouldBeNil)
+			process(statisticsObj, 6)
+			So(len(statisticsObj.buffer), ShouldEqual, 2)
+
+			buffers, err := loadState(path, identity)
+			So(err, ShouldBeNil)
+			So(len(buffers), ShouldEqual, 1)
+		})
+
+		Convey("A state file saved by a task with other options is ignored", func() {
+			_, err := loadState(path, "task")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("A corrupted state file starts from empty buffers", func() {
+			content, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+			content[len(content)/2] ^= 0xff
+			So(ioutil.WriteFile(path, content, 0644), ShouldBeNil)
+			_, err = loadState(path, identity)
+			So(err, ShouldNotBeNil)
+
+			stats := process(New(), 6)
+			So(len(stats), ShouldEqual, 2)
+			for _, m := range stats {
+				nsSlice := m.Namespace.Strings()
+				if nsSlice[len(nsSlice)-1] == sum {
+					So(m.Data, ShouldEqual, 6)
+				}
+			}
+		})
+
+		Convey("A state file of another version is ignored", func() {
+			content, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+			content[len(stateMagic)+3]++
+			So(ioutil.WriteFile(path, content, 0644), ShouldBeNil)
+			_, err = loadState(path, identity)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("A truncated state file is ignored", func() {
+			content, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+			So(ioutil.WriteFile(path, content[:len(content)-1], 0644), ShouldBeNil)
+			_, err = loadState(path, identity)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Windows estimated by a sketch are not saved", func() {
+			c, err := GetConfig(newConfig())
+			So(err, ShouldBeNil)
+			c.quantileEngine = ddsketchEngine
+			b := newDataBuffer(c)
+			for i := 1; i <= 10; i++ {
+				b.Insert(float64(i), start.Add(time.Duration(i)*time.Second))
+			}
+			So(saveState(path, newSnapshot("task", map[seriesKey]*dataBuffer{"key": b})), ShouldBeNil)
+			buffers, err := loadState(path, "task")
+			So(err, ShouldBeNil)
+			So(buffers, ShouldBeEmpty)
+		})
+	})
+}
@@ -19,25 +19,139 @@ limitations under the License.
 package statistics
 
 import (
+	"bytes"
+	"container/list"
+	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
 )
 
+// Plugin may process metrics of several tasks concurrently, mu guards its fields
+// while each buffer has its own lock so that tasks only wait for each other on shared windows
 type Plugin struct {
-	buffer map[string]*dataBuffer
+	mu       sync.Mutex
+	buffer   map[seriesKey]*dataBuffer
+	tasks    map[string]*task     // buffers of each task identity, see config.identity
+	restored map[string]bool      // state files already loaded
+	saved    map[string]time.Time // last time each state file was written
+}
+
+// task tracks the buffers of the tasks sharing an identity, so that their options only apply to these buffers
+type task struct {
+	recent  *list.List                  // usage of the buffers, most recently fed first
+	used    map[seriesKey]*list.Element // position of each buffer in recent
+	evicted int                         // number of buffers dropped by idleTimeout or maxSeries
+	skipped int                         // number of metrics dropped by onInvalid
+	lossy   int                         // number of integers too large to be converted exactly
+}
+
+// usage records when a buffer last received values
+type usage struct {
+	key seriesKey
+	fed time.Time
 }
 
 const (
 	Name    = "statistics"
 	Version = 3
+
+	// window modes
+	slidingWindow  = "sliding"
+	tumblingWindow = "tumbling"
+
+	// onInvalid policies
+	failInvalid        = "fail"
+	skipInvalid        = "skip"
+	passthroughInvalid = "passthrough"
+
+	// nonFiniteInputs policies
+	keepNonFinite  = "keep"
+	dropNonFinite  = "drop"
+	countNonFinite = "count"
+
+	// nanOutputs policies
+	omitNaN     = "omit"
+	emitNaN     = "nan"
+	sentinelNaN = "sentinel"
+
+	// legacyMethod keeps the nearest rank percentiles and the median of halves quartiles
+	legacyMethod = 0
 )
 
+// percentileMethods maps the names accepted by percentileMethod to Hyndman and Fan definitions
+var percentileMethods = map[string]int{
+	"legacy":      legacyMethod,
+	"r1":          1,
+	"r2":          2,
+	"r3":          3,
+	"r4":          4,
+	"r5":          5,
+	"r6":          6,
+	"r7":          7,
+	"r8":          8,
+	"r9":          9,
+	"nearestrank": 1,
+	"linear":      7,
+}
+
+// config holds the processing options of a task
+type config struct {
+	slidingWindowLength int
+	slidingFactor       int
+	windowDuration      time.Duration
+	windowMode          string
+	percentileMethod    int
+	quantileEngine      string
+	quantileAccuracy    float64
+	groupBy             []string
+	groupByTags         []string
+	taskID              string
+	stateFile           string
+	stateInterval       time.Duration
+	idleTimeout         time.Duration
+	maxSeries           int
+	onInvalid           string
+	parseStrings        bool
+	nonFiniteInputs     string
+	inputTransform      string
+	ewmAlpha            float64
+	ewmHalfLife         time.Duration
+	predictHorizon      time.Duration
+	forecastAlpha       float64
+	forecastBeta        float64
+	forecastGamma       float64
+	forecastDeviations  float64
+	seasonLength        int
+	anomalyThreshold    float64
+	trimFraction        float64
+	nanOutput           nanOutput
+	statistics          []string
+}
+
+// nanOutput tells what is emitted for statistics which are not a number
+type nanOutput struct {
+	policy   string
+	sentinel float64
+}
+
 // New() returns a new instance of this
 func New() *Plugin {
-	buffer := make(map[string]*dataBuffer)
-	p := &Plugin{buffer: buffer}
+	buffer := make(map[seriesKey]*dataBuffer)
+	p := &Plugin{
+		buffer:   buffer,
+		tasks:    make(map[string]*task),
+		restored: make(map[string]bool),
+		saved:    make(map[string]time.Time),
+	}
 	return p
 }
 
@@ -47,7 +161,36 @@ func (p *Plugin) GetConfigPolicy() (plugin.ConfigPolicy, error) {
 
 	policy.AddNewIntRule([]string{""}, "slidingWindowLength", false, plugin.SetDefaultInt(100), plugin.SetMinInt(1))
 	policy.AddNewIntRule([]string{""}, "slidingFactor", false, plugin.SetDefaultInt(1), plugin.SetMinInt(1))
+	policy.AddNewStringRule([]string{""}, "windowDuration", false, plugin.SetDefaultString("0s"))
+	policy.AddNewStringRule([]string{""}, "windowMode", false, plugin.SetDefaultString(slidingWindow))
 	policy.AddNewStringRule([]string{""}, "statistics", false, plugin.SetDefaultString(strings.Join(statList, ",")))
+	policy.AddNewStringRule([]string{""}, "percentiles", false, plugin.SetDefaultString(""))
+	policy.AddNewStringRule([]string{""}, "percentileMethod", false, plugin.SetDefaultString("legacy"))
+	policy.AddNewStringRule([]string{""}, "quantileEngine", false, plugin.SetDefaultString(exactEngine))
+	policy.AddNewStringRule([]string{""}, "groupBy", false, plugin.SetDefaultString(""))
+	policy.AddNewStringRule([]string{""}, "groupByTags", false, plugin.SetDefaultString(""))
+	policy.AddNewStringRule([]string{""}, "stateFile", false, plugin.SetDefaultString(""))
+	policy.AddNewStringRule([]string{""}, "stateInterval", false, plugin.SetDefaultString("1m"))
+	policy.AddNewStringRule([]string{""}, "taskId", false, plugin.SetDefaultString(""))
+	policy.AddNewStringRule([]string{""}, "idleTimeout", false, plugin.SetDefaultString("0s"))
+	policy.AddNewIntRule([]string{""}, "maxSeries", false, plugin.SetDefaultInt(0), plugin.SetMinInt(0))
+	policy.AddNewBoolRule([]string{""}, "parseStrings", false, plugin.SetDefaultBool(false))
+	policy.AddNewStringRule([]string{""}, "onInvalid", false, plugin.SetDefaultString(failInvalid))
+	policy.AddNewFloatRule([]string{""}, "alpha", false, plugin.SetDefaultFloat(0.3), plugin.SetMinFloat(0), plugin.SetMaxFloat(1))
+	policy.AddNewStringRule([]string{""}, "halfLife", false, plugin.SetDefaultString(""))
+	policy.AddNewStringRule([]string{""}, "predictHorizon", false, plugin.SetDefaultString("1m"))
+	policy.AddNewFloatRule([]string{""}, "forecastAlpha", false, plugin.SetDefaultFloat(0.5), plugin.SetMinFloat(0), plugin.SetMaxFloat(1))
+	policy.AddNewFloatRule([]string{""}, "forecastBeta", false, plugin.SetDefaultFloat(0.1), plugin.SetMinFloat(0), plugin.SetMaxFloat(1))
+	policy.AddNewFloatRule([]string{""}, "forecastGamma", false, plugin.SetDefaultFloat(0.1), plugin.SetMinFloat(0), plugin.SetMaxFloat(1))
+	policy.AddNewFloatRule([]string{""}, "forecastDeviations", false, plugin.SetDefaultFloat(1.96), plugin.SetMinFloat(0))
+	policy.AddNewIntRule([]string{""}, "seasonLength", false, plugin.SetDefaultInt(0), plugin.SetMinInt(0))
+	policy.AddNewFloatRule([]string{""}, "anomalyThreshold", false, plugin.SetDefaultFloat(3), plugin.SetMinFloat(0))
+	policy.AddNewFloatRule([]string{""}, "trimFraction", false, plugin.SetDefaultFloat(0.1), plugin.SetMinFloat(0), plugin.SetMaxFloat(0.5))
+	policy.AddNewStringRule([]string{""}, "inputTransform", false, plugin.SetDefaultString(noTransform))
+	policy.AddNewStringRule([]string{""}, "nonFiniteInputs", false, plugin.SetDefaultString(keepNonFinite))
+	policy.AddNewStringRule([]string{""}, "nanOutputs", false, plugin.SetDefaultString(omitNaN))
+	policy.AddNewFloatRule([]string{""}, "nanSentinel", false, plugin.SetDefaultFloat(0))
+	policy.AddNewFloatRule([]string{""}, "quantileAccuracy", false, plugin.SetDefaultFloat(0.01), plugin.SetMinFloat(0), plugin.SetMaxFloat(1))
 	return *policy, nil
 
 }
@@ -55,99 +198,759 @@ func (p *Plugin) GetConfigPolicy() (plugin.ConfigPolicy, error) {
 // Process processes the data, inputs the data into sorted buffer and calls the GetStats method
 func (p *Plugin) Process(metrics []plugin.Metric, cfg plugin.Config) ([]plugin.Metric, error) {
 	var result []plugin.Metric
-	slidingWindowLength, slidingFactor, stats, err := GetConfig(cfg)
+	c, err := GetConfig(cfg)
 	if err != nil {
 		return nil, err
 	}
+	now := time.Now()
+	identity := c.identity()
+	if c.stateFile != "" {
+		p.mu.Lock()
+		p.restore(c.stateFile, identity, now)
+		p.mu.Unlock()
+	}
 
+	skipped, lossy := 0, 0
+	// sliding windows grouping several namespaces are emitted once per batch, in the order they were first fed
+	var grouped []*dataBuffer
+	groupedNamespaces := make(map[*dataBuffer]plugin.Namespace)
 	for _, metric := range metrics {
 		// convert any number to float64
-		floatValue, err := dataToFloat64(metric.Data)
+		floatValue, inexact, err := dataToFloat64(metric.Data, c.parseStrings)
+		if inexact {
+			lossy++
+		}
 		if err != nil {
+			switch c.onInvalid {
+			case skipInvalid:
+				skipped++
+				continue
+			case passthroughInvalid:
+				result = append(result, metric)
+				continue
+			}
 			return nil, err
 		}
+		if c.nonFiniteInputs == dropNonFinite && (math.IsNaN(floatValue) || math.IsInf(floatValue, 0)) {
+			continue
+		}
 
-		ns := strings.Join(metric.Namespace.Strings(), "")
-		_, ok := p.buffer[ns]
-		if !ok {
-			//if there is no buffer for this particular namespace, then we create a new one
-			p.buffer[ns] = &dataBuffer{
-				data: make([]data, 0, slidingWindowLength),
-			}
-		} else {
-			if slidingWindowLength != cap(p.buffer[ns].data) {
-				// TODO: test if buffer size from the config is different than cap(p.buffer[ns])
+		// grouped namespaces share the buffer of their common namespace
+		namespace, member := metric.Namespace, ""
+		if len(c.groupBy) > 0 {
+			namespace = c.group(metric.Namespace)
+			member = strings.Join(metric.Namespace.Strings(), "/")
+		}
+
+		// values are also partitioned by the tags listed in groupByTags,
+		// and tasks configured differently never share a buffer
+		key := newSeriesKey(namespace, metric.Tags, c.groupByTags, identity)
+
+		b := p.get(key, identity, c, now)
+		mts, err := b.feed(metric, floatValue, member, namespace, c)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, mts...)
+		if member != "" && c.windowMode == slidingWindow {
+			if _, ok := groupedNamespaces[b]; !ok {
+				grouped = append(grouped, b)
+				groupedNamespaces[b] = namespace
 			}
 		}
+	}
+	for _, b := range grouped {
+		mts, err := b.emit(groupedNamespaces[b], c)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, mts...)
+	}
+
+	p.mu.Lock()
+	t := p.task(identity)
+	if c.onInvalid == skipInvalid {
+		t.skipped += skipped
+		result = append(result, selfMetric("skipped", t.skipped, "Number of metrics of the task dropped because their value is not a number"))
+	}
+	t.lossy += lossy
+	if t.lossy > 0 {
+		result = append(result, selfMetric("precisionloss", t.lossy, "Number of integers of the task which were rounded when converted to floating point"))
+	}
+	if c.idleTimeout > 0 || c.maxSeries > 0 {
+		p.evict(t, c, now)
+		result = append(result, selfMetric("evicted", t.evicted, "Number of windows of the task dropped by idleTimeout or maxSeries"))
+	}
 
-		p.buffer[ns].Insert(floatValue, metric.Timestamp)
-		// add a new element to the sorted list
-		if p.buffer[ns].slidingFactorIndex%slidingFactor == 0 {
-			mts, err := p.buffer[ns].GetStats(stats, metric.Namespace)
+	var snap snapshot
+	save := c.stateFile != "" && now.Sub(p.saved[c.stateFile]) >= c.stateInterval
+	if save {
+		// only the windows of the task are saved, the file is written once the lock is released
+		buffers := make(map[seriesKey]*dataBuffer, len(t.used))
+		for key := range t.used {
+			buffers[key] = p.buffer[key]
+		}
+		snap = newSnapshot(identity, buffers)
+		p.saved[c.stateFile] = now
+	}
+	p.mu.Unlock()
+
+	if save {
+		if err := saveState(c.stateFile, snap); err != nil {
+			// failing to save must not lose the statistics, the next call tries again
+			log.Printf("Cannot save state to %s: %v", c.stateFile, err)
+			p.mu.Lock()
+			delete(p.saved, c.stateFile)
+			p.mu.Unlock()
+		}
+	}
+	return result, nil
+}
+
+// get returns the buffer of key, created when it does not exist yet, and records that it is fed at now
+func (p *Plugin) get(key seriesKey, identity string, c config, now time.Time) *dataBuffer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t := p.task(identity)
+	b, ok := p.buffer[key]
+	if !ok {
+		//if there is no buffer for this particular namespace, then we create a new one
+		b = newDataBuffer(c)
+		p.buffer[key] = b
+		t.used[key] = t.recent.PushFront(&usage{key: key})
+	}
+	t.touch(key, now)
+	return b
+}
+
+// task returns the buffers of the tasks having identity, p.mu must be held
+func (p *Plugin) task(identity string) *task {
+	t, ok := p.tasks[identity]
+	if !ok {
+		t = &task{recent: list.New(), used: make(map[seriesKey]*list.Element)}
+		p.tasks[identity] = t
+	}
+	return t
+}
+
+// feed inserts the value of a metric in the buffer and returns the statistics to emit
+func (b *dataBuffer) feed(metric plugin.Metric, value float64, member string, namespace plugin.Namespace, c config) ([]plugin.Metric, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if c.inputTransform != noTransform {
+		if c.idleTimeout > 0 && metric.Timestamp.Sub(b.pruned) >= c.idleTimeout {
+			// the counters of grouped namespaces which stopped sending values are forgotten like idle windows
+			b.prune(metric.Timestamp.Add(-c.idleTimeout))
+			b.pruned = metric.Timestamp
+		}
+		// counters are turned into their increase since their previous sample
+		var ok bool
+		if value, ok = b.transform(member, value, metric.Timestamp, c.inputTransform); !ok {
+			return nil, nil
+		}
+		if c.inputTransform == rateTransform && metric.Unit != "" {
+			metric.Unit += "/s"
+		}
+	}
+
+	// the window length and duration are not part of the identity of a task having a taskId,
+	// they may change since the buffer was created
+	if c.windowDuration != b.duration {
+		b.Retime(c.windowDuration)
+	}
+	// a sliding window with a sketch holds more values than its length until its oldest pane leaves, see pane
+	if c.windowDuration == 0 && (c.slidingWindowLength != b.capacity || b.sketch == nil && b.n > b.capacity) {
+		b.Resize(c.slidingWindowLength)
+	}
+
+	if c.windowMode == tumblingWindow {
+		var result []plugin.Metric
+		// a tumbling window is emitted once when it closes, then it starts over empty
+		if b.Closed(metric.Timestamp) {
+			mts, err := b.GetStats(c.statistics, namespace, c.nanOutput)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, mts...)
+			b.Reset()
+		}
+		b.SetSource(metric)
+		b.InsertMember(value, metric.Timestamp, member)
+		if b.Full() {
+			mts, err := b.GetStats(c.statistics, namespace, c.nanOutput)
 			if err != nil {
 				return nil, err
 			}
 			result = append(result, mts...)
+			b.Reset()
 		}
-		p.buffer[ns].slidingFactorIndex++
+		return result, nil
+	}
+
+	b.SetSource(metric)
+	b.InsertMember(value, metric.Timestamp, member)
+	if member != "" {
+		// every member of the group is inserted before the window is emitted, see emit
+		return nil, nil
 	}
+	return b.slide(namespace, c)
+}
+
+// emit returns the statistics of a sliding window grouping several namespaces once the values of a batch are inserted
+func (b *dataBuffer) emit(namespace plugin.Namespace, c config) ([]plugin.Metric, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.slide(namespace, c)
+}
+
+// slide returns the statistics of a sliding window when the sliding factor allows it
+func (b *dataBuffer) slide(namespace plugin.Namespace, c config) ([]plugin.Metric, error) {
+	var result []plugin.Metric
+	if b.slidingFactorIndex%c.slidingFactor == 0 {
+		var err error
+		result, err = b.GetStats(c.statistics, namespace, c.nanOutput)
+		if err != nil {
+			return nil, err
+		}
+		b.cut()
+	}
+	b.slidingFactorIndex++
 	return result, nil
 }
 
+// restore loads the buffers of the state file the first time it is configured, buffers which
+// already received values are kept. A missing or corrupted file starts from empty buffers
+func (p *Plugin) restore(path, identity string, now time.Time) {
+	if p.restored[path] {
+		return
+	}
+	p.restored[path] = true
+	buffers, err := loadState(path, identity)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Ignoring state: %v", err)
+		}
+		return
+	}
+	t := p.task(identity)
+	for key, b := range buffers {
+		if _, ok := p.buffer[key]; !ok {
+			p.buffer[key] = b
+			t.used[key] = t.recent.PushFront(&usage{key: key})
+			t.touch(key, now)
+		}
+	}
+}
+
+// touch records that the buffer of key was fed at now
+func (t *task) touch(key seriesKey, now time.Time) {
+	e := t.used[key]
+	e.Value.(*usage).fed = now
+	t.recent.MoveToFront(e)
+}
+
+// evict drops the buffers of the task which were not fed during idleTimeout, then the least recently fed ones
+// until no more than maxSeries remain
+func (p *Plugin) evict(t *task, c config, now time.Time) {
+	for e := t.recent.Back(); e != nil; e = t.recent.Back() {
+		idle := c.idleTimeout > 0 && e.Value.(*usage).fed.Before(now.Add(-c.idleTimeout))
+		if !idle && (c.maxSeries == 0 || t.recent.Len() <= c.maxSeries) {
+			return
+		}
+		key := t.recent.Remove(e).(*usage).key
+		delete(t.used, key)
+		delete(p.buffer, key)
+		t.evicted++
+	}
+}
+
+// selfMetric returns a metric about the plugin itself
+func selfMetric(name string, data interface{}, description string) plugin.Metric {
+	return plugin.Metric{
+		Namespace:   plugin.NewNamespace("intel", "statistics", "self", name),
+		Timestamp:   time.Now(),
+		Unit:        dimensionless,
+		Description: description,
+		Data:        data,
+	}
+}
+
 // GetConfig returns the config policy
-func GetConfig(cfg plugin.Config) (slidingWinLen, slidingFac int, statistics []string, err error) {
+func GetConfig(cfg plugin.Config) (c config, err error) {
 	var stats string
 	stats, err = cfg.GetString("statistics")
 	if err != nil {
 		err = fmt.Errorf("\"statistics\": %v", err)
 		return
 	}
-	statistics = strings.Split(stats, ",")
+	c.statistics = strings.Split(stats, ",")
+
+	var percentiles string
+	percentiles, err = cfg.GetString("percentiles")
+	if err != nil {
+		err = fmt.Errorf("\"percentiles\": %v", err)
+		return
+	}
+	if percentiles != "" {
+		for _, token := range strings.Split(percentiles, ",") {
+			stat := "p" + strings.TrimSpace(token)
+			if _, ok := parsePercentileStat(stat); !ok {
+				err = fmt.Errorf("\"percentiles\": invalid percentile %q", token)
+				return
+			}
+			c.statistics = append(c.statistics, stat)
+		}
+	}
+
+	var method string
+	method, err = cfg.GetString("percentileMethod")
+	if err != nil {
+		err = fmt.Errorf("\"percentilemethod\": %v", err)
+		return
+	}
+	var ok bool
+	c.percentileMethod, ok = percentileMethods[method]
+	if !ok {
+		err = fmt.Errorf("\"percentilemethod\": unknown method %q", method)
+		return
+	}
+
+	c.quantileEngine, err = cfg.GetString("quantileEngine")
+	if err != nil {
+		err = fmt.Errorf("\"quantileengine\": %v", err)
+		return
+	}
+	if c.quantileEngine != exactEngine && c.quantileEngine != tdigestEngine && c.quantileEngine != ddsketchEngine {
+		err = fmt.Errorf("Unknown quantile engine %q, expected %q, %q or %q", c.quantileEngine, exactEngine, tdigestEngine, ddsketchEngine)
+		return
+	}
+	c.quantileAccuracy, err = cfg.GetFloat("quantileAccuracy")
+	if err != nil {
+		err = fmt.Errorf("\"quantileaccuracy\": %v", err)
+		return
+	}
+	if c.quantileAccuracy <= 0 || c.quantileAccuracy >= 1 {
+		err = fmt.Errorf("Quantile accuracy must be between 0 and 1 exclusive")
+		return
+	}
+
+	var groupBy string
+	groupBy, err = cfg.GetString("groupBy")
+	if err != nil {
+		err = fmt.Errorf("\"groupby\": %v", err)
+		return
+	}
+	if groupBy != "" {
+		for _, token := range strings.Split(groupBy, ",") {
+			c.groupBy = append(c.groupBy, strings.TrimSpace(token))
+		}
+	}
+
+	var groupByTags string
+	groupByTags, err = cfg.GetString("groupByTags")
+	if err != nil {
+		err = fmt.Errorf("\"groupbytags\": %v", err)
+		return
+	}
+	if groupByTags != "" {
+		for _, token := range strings.Split(groupByTags, ",") {
+			c.groupByTags = append(c.groupByTags, strings.TrimSpace(token))
+		}
+	}
+
+	c.taskID, err = cfg.GetString("taskId")
+	if err != nil {
+		err = fmt.Errorf("\"taskid\": %v", err)
+		return
+	}
+
+	c.stateFile, err = cfg.GetString("stateFile")
+	if err != nil {
+		err = fmt.Errorf("\"statefile\": %v", err)
+		return
+	}
+	var interval string
+	interval, err = cfg.GetString("stateInterval")
+	if err != nil {
+		err = fmt.Errorf("\"stateinterval\": %v", err)
+		return
+	}
+	c.stateInterval, err = time.ParseDuration(interval)
+	if err != nil {
+		err = fmt.Errorf("\"stateinterval\": %v", err)
+		return
+	}
+	if c.stateInterval < 0 {
+		err = fmt.Errorf("State interval is negative and it shouldn't be")
+		return
+	}
+
+	var idle string
+	idle, err = cfg.GetString("idleTimeout")
+	if err != nil {
+		err = fmt.Errorf("\"idletimeout\": %v", err)
+		return
+	}
+	c.idleTimeout, err = time.ParseDuration(idle)
+	if err != nil {
+		err = fmt.Errorf("\"idletimeout\": %v", err)
+		return
+	}
+	if c.idleTimeout < 0 {
+		err = fmt.Errorf("Idle timeout is negative and it shouldn't be")
+		return
+	}
+
+	c.onInvalid, err = cfg.GetString("onInvalid")
+	if err != nil {
+		err = fmt.Errorf("\"oninvalid\": %v", err)
+		return
+	}
+	if c.onInvalid != failInvalid && c.onInvalid != skipInvalid && c.onInvalid != passthroughInvalid {
+		err = fmt.Errorf("Unknown invalid value policy %q, expected %q, %q or %q", c.onInvalid, failInvalid, skipInvalid, passthroughInvalid)
+		return
+	}
+	c.parseStrings, err = cfg.GetBool("parseStrings")
+	if err != nil {
+		err = fmt.Errorf("\"parsestrings\": %v", err)
+		return
+	}
+	c.ewmAlpha, err = cfg.GetFloat("alpha")
+	if err != nil {
+		err = fmt.Errorf("\"alpha\": %v", err)
+		return
+	}
+	if c.ewmAlpha <= 0 || c.ewmAlpha > 1 {
+		err = fmt.Errorf("Alpha must be greater than 0 and at most 1")
+		return
+	}
+	var halfLife string
+	halfLife, err = cfg.GetString("halfLife")
+	if err != nil {
+		err = fmt.Errorf("\"halflife\": %v", err)
+		return
+	}
+	if halfLife != "" {
+		// a half-life is either a duration or a number of values
+		if duration, perr := time.ParseDuration(halfLife); perr == nil {
+			if duration <= 0 {
+				err = fmt.Errorf("Half-life must be positive")
+				return
+			}
+			c.ewmHalfLife = duration
+		} else if samples, perr := strconv.ParseFloat(halfLife, 64); perr == nil {
+			if !(samples > 0) {
+				err = fmt.Errorf("Half-life must be positive")
+				return
+			}
+			c.ewmAlpha = 1 - math.Pow(2, -1/samples)
+		} else {
+			err = fmt.Errorf("\"halflife\": %q is neither a duration nor a number of values", halfLife)
+			return
+		}
+	}
+	var horizon string
+	horizon, err = cfg.GetString("predictHorizon")
+	if err != nil {
+		err = fmt.Errorf("\"predicthorizon\": %v", err)
+		return
+	}
+	c.predictHorizon, err = time.ParseDuration(horizon)
+	if err != nil {
+		err = fmt.Errorf("\"predicthorizon\": %v", err)
+		return
+	}
+	c.forecastAlpha, err = cfg.GetFloat("forecastAlpha")
+	if err != nil {
+		err = fmt.Errorf("\"forecastalpha\": %v", err)
+		return
+	}
+	c.forecastBeta, err = cfg.GetFloat("forecastBeta")
+	if err != nil {
+		err = fmt.Errorf("\"forecastbeta\": %v", err)
+		return
+	}
+	c.forecastGamma, err = cfg.GetFloat("forecastGamma")
+	if err != nil {
+		err = fmt.Errorf("\"forecastgamma\": %v", err)
+		return
+	}
+	c.forecastDeviations, err = cfg.GetFloat("forecastDeviations")
+	if err != nil {
+		err = fmt.Errorf("\"forecastdeviations\": %v", err)
+		return
+	}
+	if c.forecastAlpha <= 0 || c.forecastAlpha > 1 || c.forecastBeta < 0 || c.forecastBeta > 1 || c.forecastGamma < 0 || c.forecastGamma > 1 {
+		err = fmt.Errorf("Forecast smoothing parameters must be between 0 and 1, alpha being greater than 0")
+		return
+	}
+	if c.forecastDeviations < 0 {
+		err = fmt.Errorf("Forecast deviations is negative and it shouldn't be")
+		return
+	}
+	c.anomalyThreshold, err = cfg.GetFloat("anomalyThreshold")
+	if err != nil {
+		err = fmt.Errorf("\"anomalythreshold\": %v", err)
+		return
+	}
+	if c.anomalyThreshold < 0 {
+		err = fmt.Errorf("Anomaly threshold is negative and it shouldn't be")
+		return
+	}
+	c.trimFraction, err = cfg.GetFloat("trimFraction")
+	if err != nil {
+		err = fmt.Errorf("\"trimfraction\": %v", err)
+		return
+	}
+	if c.trimFraction < 0 || c.trimFraction >= 0.5 {
+		err = fmt.Errorf("Trim fraction must be at least 0 and less than 0.5")
+		return
+	}
+	c.inputTransform, err = cfg.GetString("inputTransform")
+	if err != nil {
+		err = fmt.Errorf("\"inputtransform\": %v", err)
+		return
+	}
+	if c.inputTransform != noTransform && c.inputTransform != deltaTransform && c.inputTransform != rateTransform {
+		err = fmt.Errorf("Unknown input transform %q, expected %q, %q or %q", c.inputTransform, noTransform, deltaTransform, rateTransform)
+		return
+	}
+	c.nonFiniteInputs, err = cfg.GetString("nonFiniteInputs")
+	if err != nil {
+		err = fmt.Errorf("\"nonfiniteinputs\": %v", err)
+		return
+	}
+	if c.nonFiniteInputs != keepNonFinite && c.nonFiniteInputs != dropNonFinite && c.nonFiniteInputs != countNonFinite {
+		err = fmt.Errorf("Unknown non finite input policy %q, expected %q, %q or %q", c.nonFiniteInputs, keepNonFinite, dropNonFinite, countNonFinite)
+		return
+	}
+	c.nanOutput.policy, err = cfg.GetString("nanOutputs")
+	if err != nil {
+		err = fmt.Errorf("\"nanoutputs\": %v", err)
+		return
+	}
+	if c.nanOutput.policy != omitNaN && c.nanOutput.policy != emitNaN && c.nanOutput.policy != sentinelNaN {
+		err = fmt.Errorf("Unknown NaN output policy %q, expected %q, %q or %q", c.nanOutput.policy, omitNaN, emitNaN, sentinelNaN)
+		return
+	}
+	c.nanOutput.sentinel, err = cfg.GetFloat("nanSentinel")
+	if err != nil {
+		err = fmt.Errorf("\"nansentinel\": %v", err)
+		return
+	}
 	var tmp int64
 
+	tmp, err = cfg.GetInt("seasonLength")
+	if err != nil {
+		err = fmt.Errorf("\"seasonlength\": %v", err)
+		return
+	}
+	c.seasonLength = int(tmp)
+	if c.seasonLength < 0 {
+		err = fmt.Errorf("Season length is negative and it shouldn't be")
+		return
+	}
+
+	tmp, err = cfg.GetInt("maxSeries")
+	if err != nil {
+		err = fmt.Errorf("\"maxseries\": %v", err)
+		return
+	}
+	c.maxSeries = int(tmp)
+	if c.maxSeries < 0 {
+		err = fmt.Errorf("Max series is negative and it shouldn't be")
+		return
+	}
+
 	tmp, err = cfg.GetInt("slidingWindowLength")
 	if err != nil {
 		err = fmt.Errorf("\"slidingwindowlength\": %v", err)
 		return
 	}
-	slidingWinLen = int(tmp)
+	c.slidingWindowLength = int(tmp)
 	tmp, err = cfg.GetInt("slidingFactor")
 	if err != nil {
 		err = fmt.Errorf("\"slidingfactor\": %v", err)
 		return
 	}
-	slidingFac = int(tmp)
+	c.slidingFactor = int(tmp)
+
+	var duration string
+	duration, err = cfg.GetString("windowDuration")
+	if err != nil {
+		err = fmt.Errorf("\"windowduration\": %v", err)
+		return
+	}
+	c.windowDuration, err = time.ParseDuration(duration)
+	if err != nil {
+		err = fmt.Errorf("\"windowduration\": %v", err)
+		return
+	}
+	if c.windowDuration < 0 {
+		err = fmt.Errorf("Window duration is negative and it shouldn't be")
+		return
+	}
+
+	c.windowMode, err = cfg.GetString("windowMode")
+	if err != nil {
+		err = fmt.Errorf("\"windowmode\": %v", err)
+		return
+	}
+	if c.windowMode != slidingWindow && c.windowMode != tumblingWindow {
+		err = fmt.Errorf("Unknown window mode %q, expected %q or %q", c.windowMode, slidingWindow, tumblingWindow)
+		return
+	}
+
+	if c.quantileEngine != exactEngine {
+		// a sketch stands for the values of the window, which therefore cannot be examined again
+		for _, stat := range c.statistics {
+			if valueStats[stat] {
+				err = fmt.Errorf("Statistic %q needs the values of the window, which quantile engine %q does not keep", stat, c.quantileEngine)
+				return
+			}
+		}
+	}
 
-	if slidingFac > slidingWinLen {
+	// the window length only bounds the buffer when the window is not time based,
+	// and tumbling windows are emitted when they close regardless of the sliding factor
+	if c.windowDuration == 0 && c.windowMode == slidingWindow && c.slidingFactor > c.slidingWindowLength {
 		err = fmt.Errorf("Sliding Factor is greater than window length and it shouldn't be")
 	}
 	return
 }
 
-// converts data to float64 type
-func dataToFloat64(data interface{}) (float64, error) {
-	var value float64
+// seriesKey identifies the buffer of a namespace, of the values of the tags partitioning it and
+// of the options of the task, each part being prefixed by its length so that keys never collide
+type seriesKey string
+
+// newSeriesKey returns the key of the series of namespace with the tags listed in tagKeys, for the task of identity
+func newSeriesKey(namespace plugin.Namespace, tags map[string]string, tagKeys []string, identity string) seriesKey {
+	var key bytes.Buffer
+	write := func(s string) {
+		key.WriteString(strconv.Itoa(len(s)))
+		key.WriteByte(':')
+		key.WriteString(s)
+	}
+	write(strconv.Itoa(len(namespace)))
+	for _, element := range namespace.Strings() {
+		write(element)
+	}
+	write(strconv.Itoa(len(tagKeys)))
+	for _, tag := range tagKeys {
+		write(tag)
+		write(tags[tag])
+	}
+	write(identity)
+	return seriesKey(key.String())
+}
+
+// identity returns a hash of the options which shape the windows and the statistics calculated from them.
+// A task having a taskId owns its windows, their length and duration are left out so that they are resized
+// when the task changes them
+func (c config) identity() string {
+	h := fnv.New64a()
+	if c.taskID == "" {
+		fmt.Fprintf(h, "%d|%d|", c.slidingWindowLength, c.windowDuration)
+	} else {
+		fmt.Fprintf(h, "%q|", c.taskID)
+	}
+	fmt.Fprintf(h, "%d|%s|%d|%s|%g|%q|%q|%q|%s|%s|%g|%s|%g|%d|%d|%g|%g|%g|%g|%d|%g|%g", c.slidingFactor, c.windowMode,
+		c.percentileMethod, c.quantileEngine, c.quantileAccuracy, c.groupBy, c.groupByTags, c.statistics,
+		c.nonFiniteInputs, c.nanOutput.policy, c.nanOutput.sentinel, c.inputTransform, c.ewmAlpha, c.ewmHalfLife, c.predictHorizon,
+		c.forecastAlpha, c.forecastBeta, c.forecastGamma, c.forecastDeviations, c.seasonLength, c.anomalyThreshold, c.trimFraction)
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// group returns the namespace where the elements selected by groupBy, either by position
+// (starting at 0) or by name for dynamic elements, are replaced by "all"
+func (c config) group(ns plugin.Namespace) plugin.Namespace {
+	grouped := copyNs(ns)
+	for i, element := range ns {
+		for _, g := range c.groupBy {
+			if g == strconv.Itoa(i) || (element.IsDynamic() && g == element.Name) {
+				grouped[i] = plugin.NamespaceElement{Value: "all"}
+				break
+			}
+		}
+	}
+	return grouped
+}
+
+// converts data to float64 type, lossy reports an integer too large to be represented exactly.
+// Strings are only parsed when parseStrings is set
+func dataToFloat64(data interface{}, parseStrings bool) (value float64, lossy bool, err error) {
 	if data == nil {
-		return 0, fmt.Errorf("Data is empty : Type %T", data)
+		return 0, false, fmt.Errorf("Data is empty : Type %T", data)
 	} else {
 		switch v := data.(type) {
 		case int:
-			value = float64(data.(int))
+			return intToFloat64(int64(v))
+		case int8:
+			value = float64(v)
+		case int16:
+			value = float64(v)
 		case int32:
-			value = float64(data.(int32))
+			value = float64(v)
 		case int64:
-			value = float64(data.(int64))
+			return intToFloat64(v)
+		case uint:
+			return uintToFloat64(uint64(v))
+		case uint8:
+			value = float64(v)
+		case uint16:
+			value = float64(v)
+		case uint32:
+			value = float64(v)
+		case uint64:
+			return uintToFloat64(v)
 		case float64:
-			value = data.(float64)
+			value = v
 		case float32:
-			value = float64(data.(float32))
-		case uint64:
-			value = float64(data.(uint64))
-		case uint32:
-			value = float64(data.(uint32))
+			value = float64(v)
+		case bool:
+			if v {
+				value = 1
+			}
+		case json.Number:
+			return parseFloat64(string(v))
+		case string:
+			if !parseStrings {
+				return 0, false, fmt.Errorf("String data received while parseStrings is not set: %q", v)
+			}
+			return parseFloat64(strings.TrimSpace(v))
 		default:
-			return 0, fmt.Errorf("Unknown data received in calculateStats(): Type %T", v)
+			return 0, false, fmt.Errorf("Unknown data received in calculateStats(): Type %T", v)
 		}
 	}
-	return value, nil
+	return value, false, nil
+}
+
+// intToFloat64 converts an integer, lossy when it is rounded to another integer
+func intToFloat64(v int64) (float64, bool, error) {
+	f := float64(v)
+	// values rounded up to 2^63 do not convert back
+	return f, f >= 1<<63 || int64(f) != v, nil
+}
+
+// uintToFloat64 converts an unsigned integer, lossy when it is rounded to another integer
+func uintToFloat64(v uint64) (float64, bool, error) {
+	f := float64(v)
+	// values rounded up to 2^64 do not convert back
+	return f, f >= 1<<64 || uint64(f) != v, nil
+}
+
+// parseFloat64 parses a number, integers keep track of their precision
+func parseFloat64(s string) (float64, bool, error) {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return intToFloat64(i)
+	}
+	if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return uintToFloat64(u)
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("Data is not a number: %q", s)
+	}
+	return value, false, nil
 }
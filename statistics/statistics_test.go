@@ -22,9 +22,13 @@ limitations under the License.
 package statistics
 
 import (
+	"encoding/json"
 	"log"
 	"math"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -32,6 +36,91 @@ import (
 	. "github.com/smartystreets/goconvey/convey"
 )
 
+// newConfig returns a config holding the default value of every option
+func newConfig() plugin.Config {
+	return plugin.Config{
+		"slidingWindowLength": int64(100),
+		"slidingFactor":       int64(1),
+		"windowDuration":      "0s",
+		"windowMode":          "sliding",
+		"statistics":          strings.Join(statList, ","),
+		"percentiles":         "",
+		"percentileMethod":    "legacy",
+		"quantileEngine":      "exact",
+		"quantileAccuracy":    0.01,
+		"groupBy":             "",
+		"groupByTags":         "",
+		"stateFile":           "",
+		"stateInterval":       "1m",
+		"taskId":              "",
+		"idleTimeout":         "0s",
+		"maxSeries":           int64(0),
+		"onInvalid":           "fail",
+		"parseStrings":        false,
+		"inputTransform":      "none",
+		"alpha":               0.3,
+		"halfLife":            "",
+		"predictHorizon":      "1m",
+		"forecastAlpha":       0.5,
+		"forecastBeta":        0.1,
+		"forecastGamma":       0.1,
+		"forecastDeviations":  1.96,
+		"seasonLength":        int64(0),
+		"anomalyThreshold":    3.0,
+		"trimFraction":        0.1,
+		"nonFiniteInputs":     "keep",
+		"nanOutputs":          "omit",
+		"nanSentinel":         0.0,
+	}
+}
+
+// processMetrics feeds the values to a new plugin, one call per value, the i-th value being timestamped
+// seconds[i] seconds after the first one (i seconds when seconds is nil), and returns the metrics
+// of the last call which emitted statistics, by statistic
+func processMetrics(config plugin.Config, unit string, values []float64, seconds []int) map[string]plugin.Metric {
+	statisticsObj := New()
+	start := time.Now()
+	stats := map[string]plugin.Metric{}
+	for i, value := range values {
+		elapsed := i
+		if seconds != nil {
+			elapsed = seconds[i]
+		}
+		mts := []plugin.Metric{plugin.Metric{
+			Data:      value,
+			Namespace: plugin.NewNamespace("intel", "psutil", "load", "load1"),
+			Unit:      unit,
+			Timestamp: start.Add(time.Duration(elapsed) * time.Second),
+		}}
+		mts, err := statisticsObj.Process(mts, config)
+		So(err, ShouldBeNil)
+		if len(mts) == 0 {
+			continue
+		}
+		stats = map[string]plugin.Metric{}
+		for _, m := range mts {
+			nsSlice := m.Namespace.Strings()
+			stats[nsSlice[len(nsSlice)-1]] = m
+		}
+	}
+	return stats
+}
+
+// statValues returns the value of each statistic
+func statValues(stats map[string]plugin.Metric) map[string]interface{} {
+	values := make(map[string]interface{}, len(stats))
+	for stat, m := range stats {
+		values[stat] = m.Data
+	}
+	return values
+}
+
+// processValues feeds the values to a new plugin one second apart and returns the values of the
+// statistics of the last call which emitted some
+func processValues(config plugin.Config, values ...float64) map[string]interface{} {
+	return statValues(processMetrics(config, "", values, nil))
+}
+
 func TestStatisticsProcessor(t *testing.T) {
 	Convey("Meta should return metadata for the plugin", t, func() {
 		Convey("So Name should equal statistics", func() {
@@ -80,7 +169,7 @@ func TestStatisticsProcessorMetrics(t *testing.T) {
 			time.Now().Add(1 * time.Hour),
 		}
 
-		config := plugin.Config{}
+		config := newConfig()
 		config["slidingWindowLength"] = int64(5)
 		config["slidingFactor"] = int64(1)
 		config["statistics"] = strings.Join(statList, ",")
@@ -112,6 +201,10 @@ func TestStatisticsProcessorMetrics(t *testing.T) {
 		expected[quartilerange] = []float64{0, 15, 19, 23, 16, 15.5, 14, 12, 6, 6}
 		expected[firstquartile] = []float64{33, 33, 24, 20, 17, 8.5, 4, 4, 3, 3}
 		expected[thirdquartile] = []float64{33, 48, 43, 43, 33, 24, 18, 16, 9, 9}
+		expected[samplevariance] = []float64{math.NaN(), 200, 220.333, 253.667, 226.7, 364.3, 83.7, 47.7, 40, 17.2}
+		expected[samplestddev] = []float64{math.NaN(), 14.142, 14.844, 15.927, 15.057, 19.087, 9.149, 6.907, 6.325, 4.147}
+		expected[sampleskewness] = []float64{math.NaN(), math.NaN(), 1.044, 0.956, 1.316, 1.109, -0.36, -0.182, 1.038, -0.29}
+		expected[excesskurtosis] = []float64{math.NaN(), math.NaN(), math.NaN(), 0.723, 1.346, 2.253, -1.25, -1.35, 1.753, 0.014}
 
 		Convey("Statistics for float64 data", func() {
 			statisticsObj := New()
@@ -206,6 +299,10 @@ func TestStatisticsProcessorMetrics(t *testing.T) {
 						So(m.Data, ShouldAlmostEqual, expected[firstquartile][i], 0.01)
 					case thirdquartile:
 						So(m.Data, ShouldAlmostEqual, expected[thirdquartile][i], 0.01)
+					case samplevariance, samplestddev, sampleskewness, excesskurtosis:
+						// undefined sample moments are not emitted
+						So(math.IsNaN(expected[ns][i]), ShouldBeFalse)
+						So(m.Data, ShouldAlmostEqual, expected[ns][i], 0.01)
 					default:
 						log.Println("Raw metric found")
 						log.Printf("Data: %v", ns)
@@ -235,5 +332,1184 @@ func TestStatisticsProcessorMetrics(t *testing.T) {
 			So(metrics, ShouldNotResemble, receivedData)
 		})
 
+		Convey("Invalid window mode", func() {
+			config["windowMode"] = "hopping"
+			_, err := New().Process([]plugin.Metric{plugin.Metric{Data: 1, Namespace: plugin.NewNamespace("foo")}}, config)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Invalid window duration", func() {
+			config["windowDuration"] = "-1m"
+			_, err := New().Process([]plugin.Metric{plugin.Metric{Data: 1, Namespace: plugin.NewNamespace("foo")}}, config)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestTimeWindow(t *testing.T) {
+	Convey("Statistics over a time based window", t, func() {
+		config := newConfig()
+		config["slidingWindowLength"] = int64(5)
+		config["slidingFactor"] = int64(1)
+		config["windowDuration"] = "3m"
+		config["statistics"] = strings.Join([]string{count, sum}, ",")
+
+		data := []float64{33, 53, 24, 16, 18, 1, 7, 9, 5, 12}
+		start := time.Now()
+		expectedCount := []int{1, 2, 3, 3, 3, 3, 3, 3, 3, 3}
+		expectedSum := []float64{33, 86, 110, 93, 58, 35, 26, 17, 21, 26}
+		statisticsObj := New()
+		for i := range data {
+			mts := []plugin.Metric{plugin.Metric{
+				Data:      data[i],
+				Namespace: plugin.NewNamespace("foo", "bar"),
+				Timestamp: start.Add(time.Duration(i) * time.Minute),
+			}}
+			stats, err := statisticsObj.Process(mts, config)
+			So(err, ShouldBeNil)
+			So(len(stats), ShouldEqual, 2)
+			for _, m := range stats {
+				nsSlice := m.Namespace.Strings()
+				switch nsSlice[len(nsSlice)-1] {
+				case count:
+					So(m.Data, ShouldEqual, expectedCount[i])
+				case sum:
+					So(m.Data, ShouldAlmostEqual, expectedSum[i], 0.01)
+				}
+			}
+		}
+	})
+}
+
+func TestTumblingWindow(t *testing.T) {
+	Convey("Statistics over tumbling windows", t, func() {
+		data := []float64{33, 53, 24, 16, 18, 1, 7, 9, 5, 12}
+		config := newConfig()
+		config["slidingWindowLength"] = int64(5)
+		config["slidingFactor"] = int64(1)
+		config["windowMode"] = "tumbling"
+		config["statistics"] = sum
+
+		// process returns the sums emitted after each data point, if any
+		process := func(statisticsObj *Plugin, start time.Time, step time.Duration) map[int]float64 {
+			emitted := make(map[int]float64)
+			for i := range data {
+				mts := []plugin.Metric{plugin.Metric{
+					Data:      data[i],
+					Namespace: plugin.NewNamespace("foo", "bar"),
+					Timestamp: start.Add(time.Duration(i) * step),
+				}}
+				stats, err := statisticsObj.Process(mts, config)
+				So(err, ShouldBeNil)
+				So(len(stats), ShouldBeLessThanOrEqualTo, 1)
+				for _, m := range stats {
+					emitted[i] = m.Data.(float64)
+				}
+			}
+			return emitted
+		}
+
+		Convey("Count based windows are emitted once full", func() {
+			emitted := process(New(), time.Now(), time.Second)
+			So(emitted, ShouldResemble, map[int]float64{4: 144, 9: 34})
+		})
+
+		Convey("Time based windows are emitted once closed", func() {
+			config["windowDuration"] = "3m"
+			start := time.Date(2016, 11, 1, 0, 0, 0, 0, time.UTC)
+			emitted := process(New(), start, time.Minute)
+			So(emitted, ShouldResemble, map[int]float64{3: 110, 6: 35, 9: 21})
+		})
+	})
+}
+
+func TestPercentiles(t *testing.T) {
+	Convey("Arbitrary percentiles", t, func() {
+		data := []float64{33, 53, 24, 16, 18, 1, 7, 9, 5, 12}
+		config := newConfig()
+		config["slidingWindowLength"] = int64(5)
+		config["slidingFactor"] = int64(5)
+		config["statistics"] = "p25"
+		config["percentiles"] = "50, 90,99.9"
+
+		expected := map[string][]float64{
+			"p25":   []float64{33, 16},
+			"p50":   []float64{33, 18},
+			"p90":   []float64{33, 53},
+			"p99_9": []float64{33, 53},
+		}
+
+		start := time.Now()
+		statisticsObj := New()
+		emission := 0
+		for i := range data {
+			mts := []plugin.Metric{plugin.Metric{
+				Data:      data[i],
+				Namespace: plugin.NewNamespace("foo", "bar"),
+				Timestamp: start.Add(-time.Duration(i) * time.Hour),
+			}}
+			stats, err := statisticsObj.Process(mts, config)
+			So(err, ShouldBeNil)
+			if len(stats) == 0 {
+				continue
+			}
+			So(len(stats), ShouldEqual, len(expected))
+			for _, m := range stats {
+				nsSlice := m.Namespace.Strings()
+				stat := nsSlice[len(nsSlice)-1]
+				So(expected, ShouldContainKey, stat)
+				So(m.Data, ShouldEqual, expected[stat][emission])
+			}
+			emission++
+		}
+		So(emission, ShouldEqual, 2)
+
+		Convey("Invalid percentiles are rejected", func() {
+			config["percentiles"] = "50,101"
+			_, err := New().Process([]plugin.Metric{plugin.Metric{Data: 1, Namespace: plugin.NewNamespace("foo")}}, config)
+			So(err, ShouldNotBeNil)
+
+			config["percentiles"] = ""
+			config["statistics"] = "pfoo"
+			_, err = New().Process([]plugin.Metric{plugin.Metric{Data: 1, Namespace: plugin.NewNamespace("foo")}}, config)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestPercentileMethods(t *testing.T) {
+	Convey("Percentile methods", t, func() {
+		data := []float64{33, 53, 24, 16, 18, 1, 7, 9, 5, 12}
+		config := newConfig()
+		config["slidingWindowLength"] = int64(5)
+		config["slidingFactor"] = int64(1)
+		config["statistics"] = strings.Join([]string{"p25", "p50", "p75", "p90", firstquartile, median, thirdquartile}, ",")
+
+		// process returns the statistics emitted for each data point
+		process := func() []map[string]float64 {
+			var emitted []map[string]float64
+			start := time.Now()
+			statisticsObj := New()
+			for i := range data {
+				mts := []plugin.Metric{plugin.Metric{
+					Data:      data[i],
+					Namespace: plugin.NewNamespace("foo", "bar"),
+					Timestamp: start.Add(-time.Duration(i) * time.Hour),
+				}}
+				stats, err := statisticsObj.Process(mts, config)
+				So(err, ShouldBeNil)
+				values := make(map[string]float64)
+				for _, m := range stats {
+					nsSlice := m.Namespace.Strings()
+					values[nsSlice[len(nsSlice)-1]] = m.Data.(float64)
+				}
+				emitted = append(emitted, values)
+			}
+			return emitted
+		}
+
+		Convey("Percentiles, median and quartiles agree for every method", func() {
+			for method := range percentileMethods {
+				if method == "legacy" {
+					continue
+				}
+				config["percentileMethod"] = method
+				for _, values := range process() {
+					So(values["p25"], ShouldEqual, values[firstquartile])
+					So(values["p50"], ShouldEqual, values[median])
+					So(values["p75"], ShouldEqual, values[thirdquartile])
+				}
+			}
+		})
+
+		Convey("Percentiles follow the selected definition", func() {
+			// window of the 6th data point is 1, 16, 18, 24, 53
+			expected := map[string][]float64{
+				"nearestrank": []float64{16, 18, 24, 53},
+				"r6":          []float64{8.5, 18, 38.5, 53},
+				"linear":      []float64{16, 18, 24, 41.4},
+			}
+			for method, percentiles := range expected {
+				config["percentileMethod"] = method
+				values := process()[5]
+				So(values["p25"], ShouldAlmostEqual, percentiles[0])
+				So(values["p50"], ShouldAlmostEqual, percentiles[1])
+				So(values["p75"], ShouldAlmostEqual, percentiles[2])
+				So(values["p90"], ShouldAlmostEqual, percentiles[3])
+			}
+		})
+
+		Convey("Unknown methods are rejected", func() {
+			config["percentileMethod"] = "r10"
+			_, err := New().Process([]plugin.Metric{plugin.Metric{Data: 1, Namespace: plugin.NewNamespace("foo")}}, config)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestGroupBy(t *testing.T) {
+	Convey("Statistics across grouped namespaces", t, func() {
+		config := newConfig()
+		config["slidingWindowLength"] = int64(4)
+		config["statistics"] = strings.Join([]string{count, sum}, ",")
+
+		// cpu returns a psutil like namespace of a cpu
+		cpu := func(id string) plugin.Namespace {
+			ns := plugin.NewNamespace("intel", "psutil", "cpu").
+				AddDynamicElement("cpu_id", "ID of CPU").
+				AddStaticElement("user_percentage")
+			ns[3].Value = id
+			return ns
+		}
+
+		// process feeds one value for each cpu and returns the last statistics
+		process := func(statisticsObj *Plugin, cpus ...string) (stats []plugin.Metric) {
+			for i, id := range cpus {
+				mts := []plugin.Metric{plugin.Metric{
+					Data:      float64(i + 1),
+					Namespace: cpu(id),
+					Timestamp: time.Now(),
+				}}
+				var err error
+				stats, err = statisticsObj.Process(mts, config)
+				So(err, ShouldBeNil)
+			}
+			return
+		}
+
+		for _, groupBy := range []string{"cpu_id", "3"} {
+			config["groupBy"] = groupBy
+			stats := process(New(), "cpu0", "cpu1", "cpu2", "cpu1")
+			So(len(stats), ShouldEqual, 2)
+			for _, m := range stats {
+				nsSlice := m.Namespace.Strings()
+				So(strings.Join(nsSlice[:len(nsSlice)-1], "/"), ShouldEqual, "intel/statistics/intel/psutil/cpu/all/user_percentage")
+				So(m.Tags["members"], ShouldEqual, "3")
+				switch nsSlice[len(nsSlice)-1] {
+				case count:
+					So(m.Data, ShouldEqual, 4)
+				case sum:
+					So(m.Data, ShouldEqual, 10)
+				}
+			}
+		}
+
+		Convey("A batch holding every member is emitted once", func() {
+			config["groupBy"] = "cpu_id"
+			var mts []plugin.Metric
+			for i, id := range []string{"cpu0", "cpu1", "cpu2", "cpu3"} {
+				mts = append(mts, plugin.Metric{Data: float64(i + 1), Namespace: cpu(id), Timestamp: time.Now()})
+			}
+			stats, err := New().Process(mts, config)
+			So(err, ShouldBeNil)
+			So(len(stats), ShouldEqual, 2)
+			for _, m := range stats {
+				So(m.Tags["members"], ShouldEqual, "4")
+				if m.Namespace.Strings()[len(m.Namespace)-1] == sum {
+					So(m.Data, ShouldEqual, 10)
+				}
+			}
+		})
+
+		Convey("Members leaving the window are not counted anymore", func() {
+			stats := process(New(), "cpu0", "cpu1", "cpu2", "cpu3", "cpu3", "cpu3")
+			So(stats[0].Tags["members"], ShouldEqual, "2")
+		})
+
+		Convey("Namespaces are not grouped by default", func() {
+			config["groupBy"] = ""
+			stats := process(New(), "cpu0", "cpu1")
+			So(stats[0].Namespace.Strings()[5], ShouldEqual, "cpu1")
+			So(stats[0].Tags, ShouldNotContainKey, "members")
+		})
+	})
+}
+
+func TestGroupByTags(t *testing.T) {
+	Convey("Statistics partitioned by tags", t, func() {
+		config := newConfig()
+		config["statistics"] = sum
+		config["groupByTags"] = "plugin_running_on"
+
+		// process feeds one value for each host and returns the statistics
+		process := func(statisticsObj *Plugin, hosts ...string) (stats []plugin.Metric) {
+			for i, host := range hosts {
+				mts := []plugin.Metric{plugin.Metric{
+					Data:      float64(i + 1),
+					Namespace: plugin.NewNamespace("intel", "psutil", "load", "load1"),
+					Tags:      map[string]string{"plugin_running_on": host, "other": "tag"},
+					Timestamp: time.Now(),
+				}}
+				mts, err := statisticsObj.Process(mts, config)
+				So(err, ShouldBeNil)
+				stats = append(stats, mts...)
+			}
+			return
+		}
+
+		stats := process(New(), "host1", "host2", "host1", "host2")
+		So(len(stats), ShouldEqual, 4)
+		So(stats[2].Tags["plugin_running_on"], ShouldEqual, "host1")
+		So(stats[2].Data, ShouldEqual, 4)
+		So(stats[3].Tags["plugin_running_on"], ShouldEqual, "host2")
+		So(stats[3].Data, ShouldEqual, 6)
+		So(stats[3].Tags["other"], ShouldEqual, "tag")
+
+		Convey("Tags are not used by default", func() {
+			config["groupByTags"] = ""
+			stats := process(New(), "host1", "host2")
+			So(stats[1].Data, ShouldEqual, 3)
+		})
+	})
+}
+
+func TestMetricMetadata(t *testing.T) {
+	Convey("Statistics keep the metadata of the metrics", t, func() {
+		config := newConfig()
+		config["statistics"] = strings.Join([]string{mean, variance, count, skewness, "p99"}, ",")
+
+		mts := []plugin.Metric{plugin.Metric{
+			Data:        float64(42),
+			Namespace:   plugin.NewNamespace("intel", "psutil", "vm", "free"),
+			Tags:        map[string]string{"plugin_running_on": "host1"},
+			Unit:        "B",
+			Description: "Free memory",
+			Version:     7,
+			Timestamp:   time.Now(),
+		}}
+		stats, err := New().Process(mts, config)
+		So(err, ShouldBeNil)
+		So(len(stats), ShouldEqual, 4)
+
+		units := map[string]string{mean: "B", variance: "B^2", count: "1", "p99": "B"}
+		for _, m := range stats {
+			nsSlice := m.Namespace.Strings()
+			stat := nsSlice[len(nsSlice)-1]
+			So(m.Unit, ShouldEqual, units[stat])
+			So(m.Description, ShouldEndWith, "over the window of: Free memory")
+			So(m.Version, ShouldEqual, 7)
+			So(m.Tags["plugin_running_on"], ShouldEqual, "host1")
+			So(m.Tags, ShouldContainKey, "startTime")
+			So(m.Tags, ShouldContainKey, "stopTime")
+		}
+		// input tags are not modified
+		So(mts[0].Tags, ShouldResemble, map[string]string{"plugin_running_on": "host1"})
+	})
+
+	Convey("Units of statistics", t, func() {
+		So(statUnit(variance, "%"), ShouldEqual, "(%)^2")
+		So(statUnit(samplevariance, ""), ShouldEqual, "")
+		So(statUnit(kurtosis, "ms"), ShouldEqual, "1")
+		So(statUnit(median, "ms"), ShouldEqual, "ms")
+	})
+}
+
+func TestEviction(t *testing.T) {
+	Convey("Windows which are not fed are evicted", t, func() {
+		config := newConfig()
+		config["statistics"] = count
+		statisticsObj := New()
+
+		// process feeds one value for each namespace and returns the statistics
+		process := func(names ...string) (stats []plugin.Metric) {
+			for _, name := range names {
+				mts := []plugin.Metric{plugin.Metric{
+					Data:      float64(1),
+					Namespace: plugin.NewNamespace("intel", "docker", name, "cpu"),
+					Timestamp: time.Now(),
+				}}
+				mts, err := statisticsObj.Process(mts, config)
+				So(err, ShouldBeNil)
+				stats = append(stats, mts...)
+			}
+			return
+		}
+
+		Convey("No window is evicted by default", func() {
+			stats := process("a", "b", "c", "a")
+			So(len(stats), ShouldEqual, 4)
+			So(stats[3].Data, ShouldEqual, 2)
+			So(len(statisticsObj.buffer), ShouldEqual, 3)
+		})
+
+		Convey("Windows beyond maxSeries are evicted, least recently fed first", func() {
+			config["maxSeries"] = int64(2)
+			stats := process("a", "b", "a", "c", "b", "a")
+			So(len(stats), ShouldEqual, 12)
+			So(len(statisticsObj.buffer), ShouldEqual, 2)
+			// b was evicted by c, then a by b
+			So(stats[8].Data, ShouldEqual, 1)
+			So(stats[10].Data, ShouldEqual, 1)
+			So(stats[11].Namespace.Strings(), ShouldResemble, []string{"intel", "statistics", "self", "evicted"})
+			So(stats[11].Data, ShouldEqual, 3)
+		})
+
+		Convey("Windows idle for longer than idleTimeout are evicted", func() {
+			config["idleTimeout"] = "1ns"
+			stats := process("a", "b", "a")
+			So(len(stats), ShouldEqual, 6)
+			So(stats[4].Data, ShouldEqual, 1)
+			So(stats[5].Data, ShouldEqual, 2)
+			So(len(statisticsObj.buffer), ShouldEqual, 1)
+		})
+
+		Convey("Only the windows of the task are evicted and counted", func() {
+			other := newConfig()
+			other["statistics"] = sum
+			mts := []plugin.Metric{plugin.Metric{
+				Data:      float64(1),
+				Namespace: plugin.NewNamespace("intel", "docker", "other", "cpu"),
+				Timestamp: time.Now(),
+			}}
+			_, err := statisticsObj.Process(mts, other)
+			So(err, ShouldBeNil)
+
+			config["maxSeries"] = int64(1)
+			stats := process("a", "b")
+			So(len(statisticsObj.buffer), ShouldEqual, 2)
+			So(stats[3].Data, ShouldEqual, 1)
+		})
+
+		Convey("Negative idle timeouts are rejected", func() {
+			config["idleTimeout"] = "-1s"
+			_, err := GetConfig(config)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestConcurrentProcess(t *testing.T) {
+	Convey("Tasks can process metrics concurrently", t, func() {
+		config := newConfig()
+		config["statistics"] = strings.Join([]string{count, sum, median}, ",")
+		config["slidingWindowLength"] = int64(1000)
+		config["maxSeries"] = int64(5)
+		statisticsObj := New()
+
+		const tasks, calls = 8, 100
+		var wg sync.WaitGroup
+		errs := make(chan error, tasks*calls)
+		for task := 0; task < tasks; task++ {
+			wg.Add(1)
+			go func(task int) {
+				defer wg.Done()
+				for i := 0; i < calls; i++ {
+					// half of the tasks share a namespace, the others have their own
+					name := "shared"
+					if task%2 == 1 {
+						name = strconv.Itoa(task)
+					}
+					mts := []plugin.Metric{plugin.Metric{
+						Data:      float64(i),
+						Namespace: plugin.NewNamespace("intel", "psutil", name, "load1"),
+						Timestamp: time.Now(),
+					}}
+					_, err := statisticsObj.Process(mts, config)
+					errs <- err
+				}
+			}(task)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			So(err, ShouldBeNil)
+		}
+
+		So(len(statisticsObj.buffer), ShouldEqual, 5)
+		c, err := GetConfig(config)
+		So(err, ShouldBeNil)
+		shared := statisticsObj.buffer[newSeriesKey(plugin.NewNamespace("intel", "psutil", "shared", "load1"), nil, nil, c.identity())]
+		So(shared, ShouldNotBeNil)
+		So(shared.Count(), ShouldEqual, tasks/2*calls)
+		So(shared.Sum(), ShouldEqual, tasks/2*calls*(calls-1)/2)
+	})
+}
+
+func TestTaskIsolation(t *testing.T) {
+	Convey("Tasks configured differently have their own windows", t, func() {
+		config1 := newConfig()
+		config1["statistics"] = count
+		config1["slidingWindowLength"] = int64(10)
+		config2 := newConfig()
+		config2["statistics"] = count
+		config2["slidingWindowLength"] = int64(20)
+		statisticsObj := New()
+
+		process := func(config plugin.Config) []plugin.Metric {
+			mts := []plugin.Metric{plugin.Metric{
+				Data:      float64(1),
+				Namespace: plugin.NewNamespace("intel", "psutil", "load", "load1"),
+				Timestamp: time.Now(),
+			}}
+			mts, err := statisticsObj.Process(mts, config)
+			So(err, ShouldBeNil)
+			So(len(mts), ShouldEqual, 1)
+			return mts
+		}
+
+		process(config1)
+		process(config2)
+		process(config1)
+		So(process(config1)[0].Data, ShouldEqual, 3)
+		So(process(config2)[0].Data, ShouldEqual, 2)
+		So(len(statisticsObj.buffer), ShouldEqual, 2)
+
+		Convey("Tasks configured the same way share their windows", func() {
+			config3 := newConfig()
+			config3["statistics"] = count
+			config3["slidingWindowLength"] = int64(10)
+			config3["stateInterval"] = "2m"
+			So(process(config3)[0].Data, ShouldEqual, 4)
+		})
+
+		Convey("Tasks having a taskId own their windows", func() {
+			config3 := newConfig()
+			config3["statistics"] = count
+			config3["slidingWindowLength"] = int64(10)
+			config3["taskId"] = "load"
+			So(process(config3)[0].Data, ShouldEqual, 1)
+			config3["slidingWindowLength"] = int64(20)
+			So(process(config3)[0].Data, ShouldEqual, 2)
+			So(len(statisticsObj.buffer), ShouldEqual, 3)
+		})
+	})
+}
+
+func TestSeriesKey(t *testing.T) {
+	Convey("Namespaces never share a buffer by accident", t, func() {
+		config := newConfig()
+		config["statistics"] = count
+		statisticsObj := New()
+
+		process := func(ns plugin.Namespace, tags map[string]string) interface{} {
+			mts := []plugin.Metric{plugin.Metric{
+				Data:      float64(1),
+				Namespace: ns,
+				Tags:      tags,
+				Timestamp: time.Now(),
+			}}
+			mts, err := statisticsObj.Process(mts, config)
+			So(err, ShouldBeNil)
+			So(len(mts), ShouldEqual, 1)
+			return mts[0].Data
+		}
+
+		So(process(plugin.NewNamespace("a", "bc"), nil), ShouldEqual, 1)
+		So(process(plugin.NewNamespace("ab", "c"), nil), ShouldEqual, 1)
+		So(process(plugin.NewNamespace("abc"), nil), ShouldEqual, 1)
+		So(process(plugin.NewNamespace("a", "bc"), nil), ShouldEqual, 2)
+		So(len(statisticsObj.buffer), ShouldEqual, 3)
+
+		Convey("Tag values are kept apart from the namespace", func() {
+			config["groupByTags"] = "a,b"
+			So(process(plugin.NewNamespace("x"), map[string]string{"a": "1b=2", "b": ""}), ShouldEqual, 1)
+			So(process(plugin.NewNamespace("x"), map[string]string{"a": "1", "b": "2"}), ShouldEqual, 1)
+			So(process(plugin.NewNamespace("x"), map[string]string{"a": "1", "b": "2"}), ShouldEqual, 2)
+		})
+
+		Convey("Keys of different namespaces differ", func() {
+			So(newSeriesKey(plugin.NewNamespace("a", "bc"), nil, nil, ""), ShouldNotEqual, newSeriesKey(plugin.NewNamespace("ab", "c"), nil, nil, ""))
+			So(newSeriesKey(plugin.NewNamespace("1:a"), nil, nil, ""), ShouldNotEqual, newSeriesKey(plugin.NewNamespace("1", "a"), nil, nil, ""))
+			So(newSeriesKey(plugin.NewNamespace("a"), nil, nil, "b"), ShouldNotEqual, newSeriesKey(plugin.NewNamespace("a", "b"), nil, nil, ""))
+		})
+	})
+}
+
+func TestInvalidValues(t *testing.T) {
+	Convey("Metrics whose value is not a number", t, func() {
+		config := newConfig()
+		config["statistics"] = sum
+		statisticsObj := New()
+
+		mts := []plugin.Metric{
+			plugin.Metric{Data: float64(1), Namespace: plugin.NewNamespace("intel", "a"), Timestamp: time.Now()},
+			plugin.Metric{Data: "up", Namespace: plugin.NewNamespace("intel", "b"), Timestamp: time.Now()},
+			plugin.Metric{Data: float64(2), Namespace: plugin.NewNamespace("intel", "a"), Timestamp: time.Now()},
+			plugin.Metric{Data: nil, Namespace: plugin.NewNamespace("intel", "c"), Timestamp: time.Now()},
+		}
+
+		Convey("Fail the batch by default", func() {
+			stats, err := statisticsObj.Process(mts, config)
+			So(err, ShouldNotBeNil)
+			So(stats, ShouldBeNil)
+		})
+
+		Convey("Are dropped and counted when skipped", func() {
+			config["onInvalid"] = "skip"
+			stats, err := statisticsObj.Process(mts, config)
+			So(err, ShouldBeNil)
+			So(len(stats), ShouldEqual, 3)
+			So(stats[1].Data, ShouldEqual, 3)
+			So(stats[2].Namespace.Strings(), ShouldResemble, []string{"intel", "statistics", "self", "skipped"})
+			So(stats[2].Data, ShouldEqual, 2)
+
+			// the count of skipped metrics accumulates across calls
+			stats, err = statisticsObj.Process(mts[:2], config)
+			So(err, ShouldBeNil)
+			So(len(stats), ShouldEqual, 2)
+			So(stats[0].Data, ShouldEqual, 4)
+			So(stats[1].Data, ShouldEqual, 3)
+
+			// tasks with other options count their own metrics
+			other := newConfig()
+			other["statistics"] = count
+			other["onInvalid"] = "skip"
+			stats, err = statisticsObj.Process(mts[:2], other)
+			So(err, ShouldBeNil)
+			So(len(stats), ShouldEqual, 2)
+			So(stats[1].Data, ShouldEqual, 1)
+		})
+
+		Convey("Are forwarded unchanged when passed through", func() {
+			config["onInvalid"] = "passthrough"
+			stats, err := statisticsObj.Process(mts, config)
+			So(err, ShouldBeNil)
+			So(len(stats), ShouldEqual, 4)
+			So(stats[1].Data, ShouldEqual, "up")
+			So(stats[1].Namespace.Strings(), ShouldResemble, []string{"intel", "b"})
+			So(stats[2].Data, ShouldEqual, 3)
+			So(stats[3].Data, ShouldBeNil)
+		})
+
+		Convey("Unknown policies are rejected", func() {
+			config["onInvalid"] = "ignore"
+			_, err := GetConfig(config)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestDataToFloat64(t *testing.T) {
+	Convey("Values of any numeric type are converted", t, func() {
+		for _, data := range []interface{}{int(-3), int8(-3), int16(-3), int32(-3), int64(-3), float32(-3), float64(-3), json.Number("-3")} {
+			value, lossy, err := dataToFloat64(data, false)
+			So(err, ShouldBeNil)
+			So(lossy, ShouldBeFalse)
+			So(value, ShouldEqual, -3)
+		}
+		for _, data := range []interface{}{uint(3), uint8(3), uint16(3), uint32(3), uint64(3)} {
+			value, _, err := dataToFloat64(data, false)
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, 3)
+		}
+
+		Convey("Booleans are converted to 0 and 1", func() {
+			value, _, err := dataToFloat64(true, false)
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, 1)
+			value, _, err = dataToFloat64(false, false)
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, 0)
+		})
+
+		Convey("Strings are only parsed with parseStrings", func() {
+			_, _, err := dataToFloat64("2.5", false)
+			So(err, ShouldNotBeNil)
+			value, _, err := dataToFloat64(" 2.5 ", true)
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, 2.5)
+			_, _, err = dataToFloat64("up", true)
+			So(err, ShouldNotBeNil)
+			_, _, err = dataToFloat64(json.Number("up"), false)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Integers rounded beyond 2^53 are reported", func() {
+			_, lossy, _ := dataToFloat64(uint64(1<<53), false)
+			So(lossy, ShouldBeFalse)
+			_, lossy, _ = dataToFloat64(uint64(1<<60), false)
+			So(lossy, ShouldBeFalse)
+			_, lossy, _ = dataToFloat64(int64(-1<<63), false)
+			So(lossy, ShouldBeFalse)
+			_, lossy, _ = dataToFloat64(int64(math.MaxInt64), false)
+			So(lossy, ShouldBeTrue)
+			_, lossy, _ = dataToFloat64(uint64(1<<53+1), false)
+			So(lossy, ShouldBeTrue)
+			_, lossy, _ = dataToFloat64(int64(-1<<53-1), false)
+			So(lossy, ShouldBeTrue)
+			_, lossy, _ = dataToFloat64("18446744073709551615", true)
+			So(lossy, ShouldBeTrue)
+
+			config := newConfig()
+			config["statistics"] = count
+			mts := []plugin.Metric{
+				plugin.Metric{Data: uint64(1<<53 + 1), Namespace: plugin.NewNamespace("intel", "a"), Timestamp: time.Now()},
+			}
+			statisticsObj := New()
+			stats, err := statisticsObj.Process(mts, config)
+			So(err, ShouldBeNil)
+			So(len(stats), ShouldEqual, 2)
+			So(stats[1].Namespace.Strings(), ShouldResemble, []string{"intel", "statistics", "self", "precisionloss"})
+			So(stats[1].Data, ShouldEqual, 1)
+
+			// tasks with other options only report their own losses
+			other := newConfig()
+			other["statistics"] = sum
+			mts[0].Data = 1
+			stats, err = statisticsObj.Process(mts, other)
+			So(err, ShouldBeNil)
+			So(len(stats), ShouldEqual, 1)
+		})
+	})
+}
+
+func TestNonFiniteValues(t *testing.T) {
+	Convey("NaN and infinite values", t, func() {
+		config := newConfig()
+		config["statistics"] = strings.Join([]string{count, sum, minimum, maximum, median, nancount, infcount}, ",")
+
+		Convey("Are part of the statistics by default", func() {
+			stats := processValues(config, 1, math.NaN(), 3, math.Inf(1))
+			So(stats[count], ShouldEqual, 4)
+			So(stats, ShouldNotContainKey, sum)
+			So(stats[nancount], ShouldEqual, 1)
+			So(stats[infcount], ShouldEqual, 1)
+			So(stats, ShouldNotContainKey, maximum)
+		})
+
+		Convey("Can be dropped", func() {
+			config["nonFiniteInputs"] = "drop"
+			// dropped values emit no statistics
+			stats := processValues(config, 1, math.NaN(), 3, math.Inf(1))
+			So(stats[count], ShouldEqual, 2)
+			So(stats[sum], ShouldEqual, 4)
+			So(stats[nancount], ShouldEqual, 0)
+			So(stats[infcount], ShouldEqual, 0)
+		})
+
+		Convey("Can be counted apart from the other statistics", func() {
+			config["nonFiniteInputs"] = "count"
+			stats := processValues(config, 1, math.NaN(), 3, math.Inf(-1), math.Inf(1))
+			So(stats[count], ShouldEqual, 2)
+			So(stats[sum], ShouldEqual, 4)
+			So(stats[minimum], ShouldEqual, 1)
+			So(stats[maximum], ShouldEqual, 3)
+			So(stats[median], ShouldEqual, 2)
+			So(stats[nancount], ShouldEqual, 1)
+			So(stats[infcount], ShouldEqual, 2)
+		})
+
+		Convey("Infinite statistics follow the same policy", func() {
+			stats := processValues(config, 1, math.Inf(1))
+			So(stats, ShouldNotContainKey, sum)
+			So(stats[minimum], ShouldEqual, 1)
+
+			config["nanOutputs"] = "nan"
+			stats = processValues(config, 1, math.Inf(1))
+			So(stats[sum], ShouldEqual, math.Inf(1))
+			So(stats[maximum], ShouldEqual, math.Inf(1))
+
+			config["nanOutputs"] = "sentinel"
+			config["nanSentinel"] = -1.0
+			stats = processValues(config, 1, math.Inf(-1))
+			So(stats[sum], ShouldEqual, -1)
+			So(stats[minimum], ShouldEqual, -1)
+			So(stats[maximum], ShouldEqual, 1)
+		})
+
+		Convey("Statistics which are not a number", func() {
+			config["nonFiniteInputs"] = "count"
+			Convey("Are omitted by default", func() {
+				stats := processValues(config, math.NaN())
+				So(stats, ShouldNotContainKey, median)
+				So(stats[nancount], ShouldEqual, 1)
+			})
+			Convey("Can be emitted as NaN", func() {
+				config["nanOutputs"] = "nan"
+				stats := processValues(config, math.NaN())
+				So(math.IsNaN(stats[median].(float64)), ShouldBeTrue)
+			})
+			Convey("Can be replaced by a sentinel", func() {
+				config["nanOutputs"] = "sentinel"
+				config["nanSentinel"] = -1.0
+				stats := processValues(config, math.NaN())
+				So(stats[median], ShouldEqual, -1)
+				So(stats[minimum], ShouldEqual, -1)
+			})
+		})
+
+		Convey("Unknown policies are rejected", func() {
+			config["nonFiniteInputs"] = "ignore"
+			_, err := GetConfig(config)
+			So(err, ShouldNotBeNil)
+			config["nonFiniteInputs"] = "keep"
+			config["nanOutputs"] = "zero"
+			_, err = GetConfig(config)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestInputTransform(t *testing.T) {
+	Convey("Counters are transformed before calculating statistics", t, func() {
+		config := newConfig()
+		config["statistics"] = strings.Join([]string{count, sum}, ",")
+		start := time.Now()
+
+		// process feeds the counter values, one per second, and returns the last statistics emitted by name
+		process := func(statisticsObj *Plugin, values ...float64) map[string]interface{} {
+			stats := map[string]interface{}{}
+			for _, value := range values {
+				mts := []plugin.Metric{plugin.Metric{
+					Data:      value,
+					Namespace: plugin.NewNamespace("intel", "psutil", "net", "eth0", "packets_recv"),
+					Unit:      "packets",
+					Timestamp: start,
+				}}
+				start = start.Add(2 * time.Second)
+				mts, err := statisticsObj.Process(mts, config)
+				So(err, ShouldBeNil)
+				for _, m := range mts {
+					nsSlice := m.Namespace.Strings()
+					stats[nsSlice[len(nsSlice)-1]] = m.Data
+					if nsSlice[len(nsSlice)-1] == sum {
+						stats["unit"] = m.Unit
+					}
+				}
+			}
+			return stats
+		}
+
+		Convey("Into deltas", func() {
+			config["inputTransform"] = "delta"
+			statisticsObj := New()
+			So(process(statisticsObj, 100), ShouldBeEmpty)
+			stats := process(statisticsObj, 110, 130)
+			So(stats[count], ShouldEqual, 2)
+			So(stats[sum], ShouldEqual, 30)
+			So(stats["unit"], ShouldEqual, "packets")
+		})
+
+		Convey("Into rates per second", func() {
+			config["inputTransform"] = "rate"
+			stats := process(New(), 100, 110, 130)
+			So(stats[count], ShouldEqual, 2)
+			So(stats[sum], ShouldEqual, 15)
+			So(stats["unit"], ShouldEqual, "packets/s")
+		})
+
+		Convey("Skipping counter resets", func() {
+			config["inputTransform"] = "delta"
+			stats := process(New(), 100, 110, 5, 15)
+			So(stats[count], ShouldEqual, 2)
+			So(stats[sum], ShouldEqual, 20)
+		})
+
+		Convey("Across wraparounds", func() {
+			config["inputTransform"] = "delta"
+			stats := process(New(), 1<<32-10, 5)
+			So(stats[count], ShouldEqual, 1)
+			So(stats[sum], ShouldEqual, 15)
+			stats = process(New(), 1<<64-4096, 4096)
+			So(stats[sum], ShouldEqual, 8192)
+		})
+
+		Convey("Forgetting the counters of grouped namespaces which are gone", func() {
+			config["inputTransform"] = "delta"
+			config["groupBy"] = "3"
+			config["slidingWindowLength"] = int64(2)
+			statisticsObj := New()
+			feed := func(iface string, value float64, seconds int) {
+				mts := []plugin.Metric{plugin.Metric{
+					Data:      value,
+					Namespace: plugin.NewNamespace("intel", "psutil", "net", iface, "packets_recv"),
+					Timestamp: start.Add(time.Duration(seconds) * time.Second),
+				}}
+				_, err := statisticsObj.Process(mts, config)
+				So(err, ShouldBeNil)
+			}
+			counters := func() (names []string) {
+				So(len(statisticsObj.buffer), ShouldEqual, 1)
+				for _, b := range statisticsObj.buffer {
+					for name := range b.counters {
+						names = append(names, strings.Split(name, "/")[3])
+					}
+				}
+				sort.Strings(names)
+				return
+			}
+
+			feed("eth0", 100, 0)
+			feed("eth1", 100, 0)
+			feed("eth0", 110, 1)
+			feed("eth1", 110, 1)
+			feed("eth2", 100, 2)
+			So(counters(), ShouldResemble, []string{"eth0", "eth1", "eth2"})
+
+			// eth0 leaves the window, then eth1
+			feed("eth2", 120, 3)
+			So(counters(), ShouldResemble, []string{"eth1", "eth2"})
+			feed("eth2", 130, 4)
+			So(counters(), ShouldResemble, []string{"eth2"})
+
+			Convey("But not when their only value is replaced by their next one", func() {
+				statisticsObj = New()
+				for i := 0; i < 4; i++ {
+					var mts []plugin.Metric
+					for _, iface := range []string{"eth0", "eth1"} {
+						mts = append(mts, plugin.Metric{
+							Data:      float64(100 + 10*i),
+							Namespace: plugin.NewNamespace("intel", "psutil", "net", iface, "packets_recv"),
+							Timestamp: start.Add(time.Duration(i) * time.Second),
+						})
+					}
+					stats, err := statisticsObj.Process(mts, config)
+					So(err, ShouldBeNil)
+					So(counters(), ShouldResemble, []string{"eth0", "eth1"})
+					if i > 0 {
+						So(len(stats), ShouldEqual, 2)
+						for _, m := range stats {
+							nsSlice := m.Namespace.Strings()
+							switch nsSlice[len(nsSlice)-1] {
+							case count:
+								So(m.Data, ShouldEqual, 2)
+							case sum:
+								So(m.Data, ShouldEqual, 20)
+							}
+						}
+					}
+				}
+			})
+
+			Convey("Or after idleTimeout when they never were in the window", func() {
+				config["idleTimeout"] = "10s"
+				feed("eth3", 100, 5)
+				So(counters(), ShouldResemble, []string{"eth2", "eth3"})
+				feed("eth2", 140, 20)
+				So(counters(), ShouldResemble, []string{"eth2"})
+			})
+		})
+
+		Convey("Unknown transforms are rejected", func() {
+			config["inputTransform"] = "integral"
+			_, err := GetConfig(config)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestEWM(t *testing.T) {
+	Convey("Exponentially weighted moving statistics", t, func() {
+		config := newConfig()
+		config["statistics"] = strings.Join([]string{ewma, ewmvar, ewmstd}, ",")
+		config["slidingWindowLength"] = int64(2)
+		Convey("Weigh every value with alpha, whatever the window", func() {
+			config["alpha"] = 0.5
+			stats := statValues(processMetrics(config, "", []float64{1, 3, 5, math.NaN()}, []int{0, 1, 2, 3}))
+			// mean 1 then 2 then 3.5, variance 0 then 1 then 0.5*(1+3*1.5)
+			So(stats[ewma], ShouldAlmostEqual, 3.5)
+			So(stats[ewmvar], ShouldAlmostEqual, 2.75)
+			So(stats[ewmstd], ShouldAlmostEqual, math.Sqrt(2.75))
+		})
+
+		Convey("Derive alpha from a half-life in values", func() {
+			config["halfLife"] = "1"
+			stats := statValues(processMetrics(config, "", []float64{1, 3}, []int{0, 1}))
+			So(stats[ewma], ShouldAlmostEqual, 2)
+		})
+
+		Convey("Weigh irregular values by the time elapsed with a half-life in time", func() {
+			config["halfLife"] = "10s"
+			stats := statValues(processMetrics(config, "", []float64{0, 4, 4}, []int{0, 10, 30}))
+			// 2 after one half-life, then 4 - 2/4 after two more
+			So(stats[ewma], ShouldAlmostEqual, 3.5)
+		})
+
+		Convey("Reject invalid half-lives", func() {
+			for _, halfLife := range []string{"0s", "-1", "0", "soon"} {
+				config["halfLife"] = halfLife
+				_, err := GetConfig(config)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestTrend(t *testing.T) {
+	Convey("Trend of the values of the window", t, func() {
+		config := newConfig()
+		config["statistics"] = strings.Join([]string{slope, intercept, r2, derivative, predict}, ",")
+		config["slidingWindowLength"] = int64(4)
+		Convey("Values on a line are fitted exactly", func() {
+			// the first value leaves the window
+			stats := processMetrics(config, "B", []float64{0, 10, 12, 16, 20}, []int{0, 1, 2, 4, 6})
+			So(stats[slope].Data, ShouldAlmostEqual, 2)
+			So(stats[slope].Unit, ShouldEqual, "B/s")
+			So(stats[intercept].Data, ShouldAlmostEqual, 10)
+			So(stats[r2].Data, ShouldAlmostEqual, 1)
+			So(stats[r2].Unit, ShouldEqual, "1")
+			So(stats[derivative].Data, ShouldAlmostEqual, 2)
+			// 60 seconds after the newest value, 65 seconds after the oldest one
+			So(stats[predict].Data, ShouldAlmostEqual, 140)
+			So(stats[predict].Unit, ShouldEqual, "B")
+		})
+
+		Convey("Scattered values", func() {
+			config["predictHorizon"] = "0s"
+			stats := processMetrics(config, "B", []float64{1, 3, 2, 4}, []int{0, 1, 2, 3})
+			So(stats[slope].Data, ShouldAlmostEqual, 0.8)
+			So(stats[intercept].Data, ShouldAlmostEqual, 1.3)
+			So(stats[r2].Data, ShouldAlmostEqual, 0.64)
+			So(stats[derivative].Data, ShouldAlmostEqual, 1)
+			So(stats[predict].Data, ShouldAlmostEqual, 3.7)
+		})
+
+		Convey("A single timestamp gives no trend", func() {
+			stats := processMetrics(config, "B", []float64{1}, []int{0})
+			So(stats, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestForecast(t *testing.T) {
+	Convey("Holt-Winters forecasts", t, func() {
+		config := newConfig()
+		config["statistics"] = strings.Join([]string{forecast, forecastlower, forecastupper, residual}, ",")
+		config["slidingWindowLength"] = int64(2)
+
+		// process feeds the values and returns the last statistics by name
+		Convey("Follow the trend", func() {
+			config["forecastAlpha"] = 1.0
+			config["forecastBeta"] = 1.0
+			stats := processValues(config, 0)
+			So(stats[forecast], ShouldEqual, 0)
+			So(stats, ShouldNotContainKey, residual)
+
+			stats = processValues(config, 0, 2, 4)
+			So(stats[forecast], ShouldAlmostEqual, 6)
+			So(stats[residual], ShouldAlmostEqual, 0)
+			So(stats[forecastlower], ShouldAlmostEqual, 6)
+			So(stats[forecastupper], ShouldAlmostEqual, 6)
+
+			stats = processValues(config, 0, 2, 4, 7)
+			So(stats[forecast], ShouldAlmostEqual, 10)
+			So(stats[residual], ShouldAlmostEqual, 1)
+			So(stats[forecastlower], ShouldAlmostEqual, 10-1.96)
+			So(stats[forecastupper], ShouldAlmostEqual, 10+1.96)
+		})
+
+		Convey("Follow the season", func() {
+			config["seasonLength"] = int64(2)
+			So(processValues(config, 1), ShouldBeEmpty)
+			stats := processValues(config, 1, 3, 1, 3, 1)
+			So(stats[forecast], ShouldAlmostEqual, 3)
+			So(stats[residual], ShouldAlmostEqual, 0)
+		})
+
+		Convey("Reject invalid smoothing parameters", func() {
+			config["forecastAlpha"] = 0.0
+			_, err := GetConfig(config)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestAnomalies(t *testing.T) {
+	Convey("Anomaly scores of the newest value", t, func() {
+		config := newConfig()
+		config["statistics"] = strings.Join([]string{zscore, robustzscore, isanomaly}, ",")
+		config["slidingWindowLength"] = int64(5)
+
+		// process feeds the values and returns the last statistics by name
+		Convey("A usual value is not an anomaly", func() {
+			stats := processValues(config, 1, 2, 3, 4, 5)
+			// mean 3, population standard deviation sqrt(2), median 3, MAD 1
+			So(stats[zscore], ShouldAlmostEqual, 2/math.Sqrt(2))
+			So(stats[robustzscore], ShouldAlmostEqual, 2/1.4826)
+			So(stats[isanomaly], ShouldEqual, 0)
+		})
+
+		Convey("A spike is an anomaly", func() {
+			stats := processValues(config, 10, 11, 9, 10, 100)
+			// median 10, MAD 1
+			So(stats[robustzscore], ShouldAlmostEqual, 90/1.4826)
+			So(stats[isanomaly], ShouldEqual, 1)
+			So(stats[zscore].(float64), ShouldBeLessThan, 2)
+
+			Convey("Unless the threshold is higher", func() {
+				config["anomalyThreshold"] = 100.0
+				stats := processValues(config, 10, 11, 9, 10, 100)
+				So(stats[isanomaly], ShouldEqual, 0)
+			})
+		})
+
+		Convey("A window whose MAD is 0 falls back on the mean absolute deviation", func() {
+			stats := processValues(config, 5, 5, 5, 5, 6)
+			// median 5, MAD 0, mean absolute deviation 0.2
+			So(stats[robustzscore], ShouldAlmostEqual, 1/(1.2533*0.2))
+			So(stats[isanomaly], ShouldEqual, 1)
+
+			stats = processValues(config, 5, 5, 5, 6, 5)
+			So(stats[robustzscore], ShouldEqual, 0)
+			So(stats[isanomaly], ShouldEqual, 0)
+
+			stats = processValues(config, 5, 5, 5, 5, 5)
+			So(stats[robustzscore], ShouldEqual, 0)
+			So(stats[isanomaly], ShouldEqual, 0)
+		})
+	})
+}
+
+func TestRobustStatistics(t *testing.T) {
+	Convey("Robust statistics are not dominated by spikes", t, func() {
+		config := newConfig()
+		config["statistics"] = strings.Join([]string{mean, mad, trimmedmean, winsorizedmean, iqrmean}, ",")
+		config["slidingWindowLength"] = int64(10)
+		config["trimFraction"] = 0.2
+
+		statisticsObj := New()
+		stats := map[string]interface{}{}
+		for _, value := range []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 1000} {
+			mts := []plugin.Metric{plugin.Metric{
+				Data:      value,
+				Namespace: plugin.NewNamespace("intel", "psutil", "latency"),
+				Timestamp: time.Now(),
+			}}
+			mts, err := statisticsObj.Process(mts, config)
+			So(err, ShouldBeNil)
+			for _, m := range mts {
+				nsSlice := m.Namespace.Strings()
+				stats[nsSlice[len(nsSlice)-1]] = m.Data
+			}
+		}
+
+		So(stats[mean], ShouldAlmostEqual, 104.5)
+		// median 5.5, absolute deviations 0.5, 0.5, 1.5, 1.5, ... 994.5
+		So(stats[mad], ShouldAlmostEqual, 2.5)
+		// 3 to 8
+		So(stats[trimmedmean], ShouldAlmostEqual, 5.5)
+		// 3, 3, 3 to 8, 8, 8
+		So(stats[winsorizedmean], ShouldAlmostEqual, 5.5)
+		// half of 3 and 8, and 4 to 7
+		So(stats[iqrmean], ShouldAlmostEqual, 5.5)
+
+		Convey("The interquartile mean does not depend on values lying between the quartiles", func() {
+			config["percentileMethod"] = "linear"
+			config["slidingWindowLength"] = int64(2)
+			stats := processValues(config, 0, 10)
+			So(stats[iqrmean], ShouldAlmostEqual, 5)
+
+			config["slidingWindowLength"] = int64(1)
+			stats = processValues(config, 7)
+			So(stats[iqrmean], ShouldAlmostEqual, 7)
+		})
+
+		Convey("Without trimming, trimmed and winsorized means are the mean", func() {
+			config["trimFraction"] = 0.0
+			c, err := GetConfig(config)
+			So(err, ShouldBeNil)
+			buffer := newDataBuffer(c)
+			for i, value := range []float64{1, 2, 3, 1000} {
+				buffer.Insert(value, time.Now().Add(time.Duration(i)*time.Second))
+			}
+			So(buffer.TrimmedMean(), ShouldAlmostEqual, 251.5)
+			So(buffer.WinsorizedMean(), ShouldAlmostEqual, 251.5)
+		})
+
+		Convey("Trim fractions of half or more are rejected", func() {
+			config["trimFraction"] = 0.5
+			_, err := GetConfig(config)
+			So(err, ShouldNotBeNil)
+		})
 	})
 }
@@ -3,19 +3,30 @@ package statistics
 import (
 	"fmt"
 	"math"
+	"sort"
 )
 
 // Returns count of the buffer
 func (d *dataBuffer) Count() int {
-	return len(d.data)
+	if d.finiteOnly {
+		return d.finite()
+	}
+	return d.n
 }
 
 // Returns sum of all the data values in the buffer
 func (d *dataBuffer) Sum() (sum float64) {
-	for _, val := range d.data {
-		sum += val.value
+	switch {
+	case d.finiteOnly:
+		return d.sum
+	case d.nan > 0 || (d.posInf > 0 && d.negInf > 0):
+		return math.NaN()
+	case d.posInf > 0:
+		return math.Inf(1)
+	case d.negInf > 0:
+		return math.Inf(-1)
 	}
-	return
+	return d.sum
 }
 
 // Returns the mean of the buffer
@@ -25,12 +36,69 @@ func (d *dataBuffer) Mean(sum float64, count int) float64 {
 
 // Returns the minimum value in the buffer
 func (d *dataBuffer) Minimum() float64 {
-	return d.data[0].value
+	if d.sketch != nil {
+		// NaN and -Inf come first, as in the sorted values
+		switch {
+		case d.finiteOnly:
+		case d.nan > 0:
+			return math.NaN()
+		case d.negInf > 0:
+			return math.Inf(-1)
+		case d.finite() == 0:
+			return math.Inf(1)
+		}
+		if d.finite() == 0 {
+			return math.NaN()
+		}
+		return d.min
+	}
+	return d.value(0)
 }
 
 // Returns the maximum value in the buffer
 func (d *dataBuffer) Maximum() float64 {
-	return d.data[len(d.data)-1].value
+	if d.sketch != nil {
+		// +Inf comes last, as in the sorted values
+		switch {
+		case d.finiteOnly:
+		case d.posInf > 0:
+			return math.Inf(1)
+		case d.finite() == 0 && d.negInf > 0:
+			return math.Inf(-1)
+		}
+		if d.finite() == 0 {
+			return math.NaN()
+		}
+		return d.max
+	}
+	return d.value(d.Count() - 1)
+}
+
+// value returns the k-th smallest value in the buffer, k starting at 0
+func (d *dataBuffer) value(k int) float64 {
+	if d.finiteOnly {
+		// NaN and -Inf come first
+		k += d.nan + d.negInf
+	}
+	return d.sorted.Select(k)
+}
+
+// each calls f with the values of the buffer in arrival order
+func (d *dataBuffer) each(f func(float64)) {
+	d.eachData(func(x data) {
+		f(x.value)
+	})
+}
+
+// eachData calls f with the values of the buffer and their timestamp in arrival order
+func (d *dataBuffer) eachData(f func(data)) {
+	for i := 0; i < d.window.len(); i++ {
+		x := d.window.at(i)
+		if d.finiteOnly && (math.IsNaN(x.value) || math.IsInf(x.value, 0)) {
+			continue
+		}
+		f(x)
+	}
 }
 
 // Returns the range of the data buffer
@@ -38,19 +106,21 @@ func (d *dataBuffer) Range(min, max float64) float64 {
 	return (max - min)
 }
 
-// Calculates and returns variance from mean
-func (d *dataBuffer) Variance(mean float64) (variance float64) {
-	var total float64
-	l := len(d.data)
-	if l == 1 {
-		return 0
+// Returns the variance, maintained while values enter and leave the buffer
+func (d *dataBuffer) Variance() float64 {
+	if d.finite() != d.Count() {
+		return math.NaN()
 	}
+	return d.m2 / float64(d.Count())
+}
 
-	for _, val := range d.data {
-		total += math.Pow(val.value-mean, 2)
+// Returns the sample variance, using Bessel's correction
+func (d *dataBuffer) SampleVariance() float64 {
+	l := d.Count()
+	if l < 2 || d.finite() != l {
+		return math.NaN()
 	}
-	variance = total / float64(len(d.data))
-	return
+	return d.m2 / float64(l-1)
 }
 
 // Calculates and returns standard deviation from variance
@@ -60,18 +130,21 @@ func (d *dataBuffer) StandardDeviation(variance float64) float64 {
 
 // Returns median of the data buffer
 func (d *dataBuffer) Median() (median float64) {
-	l := len(d.data)
+	if d.method != legacyMethod || d.sketch != nil {
+		return d.Quantile(0.5, d.method)
+	}
+	l := d.Count()
 	if l%2 == 0 {
-		median = (d.data[l/2-1].value + d.data[l/2].value) / 2
+		median = (d.value(l/2-1) + d.value(l/2)) / 2
 	} else {
-		median = d.data[l/2].value
+		median = d.value(l / 2)
 	}
 	return
 }
 
 // Calculates the percentile based on the percent that is being passed as input
 func (d *dataBuffer) PercentileNearestRank(percent float64) (float64, error) {
-	l := len(d.data)
+	l := d.Count()
 	//return error less than 0 or greater than 100 percentages
 	if percent < 0 || percent > 100 {
 		return 0, fmt.Errorf("not a valid percent")
@@ -82,27 +155,102 @@ func (d *dataBuffer) PercentileNearestRank(percent float64) (float64, error) {
 
 	//return the item that is in the place of the ordinal rank
 	if or == 0 {
-		return d.data[0].value, nil
+		return d.value(0), nil
+	}
+	return d.value(or - 1), nil
+}
+
+// Calculates the percentile following the percentile method of the buffer
+func (d *dataBuffer) Percentile(percent float64) (float64, error) {
+	if d.method == legacyMethod && d.sketch == nil {
+		return d.PercentileNearestRank(percent)
+	}
+	if percent < 0 || percent > 100 {
+		return 0, fmt.Errorf("not a valid percent")
 	}
-	return d.data[or-1].value, nil
+	return d.Quantile(percent/100, d.method), nil
+}
+
+// Quantile returns the p-quantile (0 <= p <= 1) of the data buffer following
+// the definition number 1 to 9 from Hyndman and Fan, "Sample Quantiles in Statistical Packages" (1996),
+// which are the types of R's quantile() function.
+// When the buffer has a quantile sketch, the quantile is estimated by the sketch whatever the method
+func (d *dataBuffer) Quantile(p float64, method int) float64 {
+	if d.sketch != nil {
+		return d.sketch.Quantile(p)
+	}
+	l := d.Count()
+	n := float64(l)
+	// x returns the k-th smallest value, k starting at 1 and being clamped to the buffer
+	x := func(k int) float64 {
+		if k < 1 {
+			k = 1
+		} else if k > l {
+			k = l
+		}
+		return d.value(k - 1)
+	}
+
+	// discontinuous definitions
+	switch method {
+	case 1:
+		return x(int(math.Ceil(n * p)))
+	case 2:
+		h := n * p
+		if h == math.Floor(h) {
+			return (x(int(h)) + x(int(h)+1)) / 2
+		}
+		return x(int(math.Ceil(h)))
+	case 3:
+		h := n*p - 0.5
+		j := math.Floor(h)
+		if h == j && int(j)%2 == 0 {
+			return x(int(j))
+		}
+		return x(int(j) + 1)
+	}
+
+	// continuous definitions interpolate linearly between the order statistics around position h
+	var h float64
+	switch method {
+	case 4:
+		h = n * p
+	case 5:
+		h = n*p + 0.5
+	case 6:
+		h = (n + 1) * p
+	case 7:
+		h = (n-1)*p + 1
+	case 8:
+		h = (n+1.0/3)*p + 1.0/3
+	case 9:
+		h = (n+0.25)*p + 3.0/8
+	}
+	if h <= 1 {
+		return x(1)
+	} else if h >= n {
+		return x(l)
+	}
+	lo := math.Floor(h)
+	return x(int(lo)) + (h-lo)*(x(int(lo)+1)-x(int(lo)))
 }
 
 // Returns mode of the data buffer
 func (d *dataBuffer) Mode() (modes []float64) {
-	frequencies := make(map[float64]int, len(d.data))
+	frequencies := make(map[float64]int, d.Count())
 	highestFrequency := 0
-	for _, x := range d.data {
-		frequencies[x.value]++
-		if frequencies[x.value] > highestFrequency {
-			highestFrequency = frequencies[x.value]
+	d.each(func(x float64) {
+		frequencies[x]++
+		if frequencies[x] > highestFrequency {
+			highestFrequency = frequencies[x]
 		}
-	}
+	})
 	for x, frequency := range frequencies {
 		if frequency == highestFrequency {
 			modes = append(modes, x)
 		}
 	}
-	if highestFrequency == 1 || len(modes) == len(d.data) {
+	if highestFrequency == 1 || len(modes) == d.Count() {
 		modes = modes[:0]
 	}
 	return
@@ -110,7 +258,10 @@ func (d *dataBuffer) Mode() (modes []float64) {
 
 // First quartile returns the first quartile point which is the middle number between the smallest number and the median of the data set
 func (d *dataBuffer) FirstQuartile() (quartile float64) {
-	l := len(d.data)
+	if d.method != legacyMethod || d.sketch != nil {
+		return d.Quantile(0.25, d.method)
+	}
+	l := d.Count()
 
 	//find the cutoff places depending on if the input slice length is even or odd
 	if l%2 == 0 {
@@ -119,11 +270,11 @@ func (d *dataBuffer) FirstQuartile() (quartile float64) {
 		l = (l - 1) / 2
 	}
 	if l == 0 {
-		return d.data[0].value
+		return d.value(0)
 	} else if l%2 == 0 {
-		quartile = (d.data[l/2-1].value + d.data[l/2].value) / 2
+		quartile = (d.value(l/2-1) + d.value(l/2)) / 2
 	} else {
-		quartile = d.data[l/2].value
+		quartile = d.value(l / 2)
 	}
 
 	return
@@ -132,19 +283,22 @@ func (d *dataBuffer) FirstQuartile() (quartile float64) {
 /* Third quartile returns the third quartile point from a slice of data,
 which is the middle value between the median and the highest value of the data set */
 func (d *dataBuffer) ThirdQuartile() (quartile float64) {
-	l := len(d.data)
+	if d.method != legacyMethod || d.sketch != nil {
+		return d.Quantile(0.75, d.method)
+	}
+	l := d.Count()
 	if l == 1 {
-		return d.data[0].value
+		return d.value(0)
 	} else if l == 2 {
-		return d.data[0].value*0.25 + d.data[1].value*0.75
+		return d.value(0)*0.25 + d.value(1)*0.75
 	} else {
 		c1 := l / 2
 		c2 := l - 1
 		l = (c2 - c1) + 1
 		if l%2 == 0 {
-			quartile = (d.data[c1+(l/2)-1].value + d.data[c1+(l/2)].value) / 2
+			quartile = (d.value(c1+(l/2)-1) + d.value(c1+(l/2))) / 2
 		} else {
-			quartile = d.data[c1+(l/2)].value
+			quartile = d.value(c1 + (l / 2))
 		}
 	}
 
@@ -163,21 +317,199 @@ func (d *dataBuffer) Trimean(firstquartile, median, thirdquartile float64) float
 
 //Calculates the population skewness from the data buffer
 func (d *dataBuffer) Skewness(mean, stdev float64) (skew float64) {
-	l := len(d.data)
-
-	for _, val := range d.data {
-		skew += math.Pow((val.value-mean)/stdev, 3)
+	l := d.Count()
+	if d.sketch != nil {
+		return d.m3 / float64(l) / math.Pow(stdev, 3)
 	}
 
+	d.each(func(x float64) {
+		skew += math.Pow((x-mean)/stdev, 3)
+	})
+
 	return 1.0 / float64(l) * skew
 }
 
 //Calculates the population kurtosis from the data buffer
 func (d *dataBuffer) Kurtosis(mean, stdev float64) (kurt float64) {
-	l := len(d.data)
-
-	for _, val := range d.data {
-		kurt += math.Pow((val.value-mean)/stdev, 4)
+	l := d.Count()
+	if d.sketch != nil {
+		return d.m4 / float64(l) / math.Pow(stdev, 4)
 	}
+
+	d.each(func(x float64) {
+		kurt += math.Pow((x-mean)/stdev, 4)
+	})
 	return 1.0 / float64(l) * kurt
 }
+
+// Calculates the sample skewness (adjusted Fisher-Pearson standardized moment coefficient) from the population skewness
+func (d *dataBuffer) SampleSkewness(skew float64) float64 {
+	n := float64(d.Count())
+	if n < 3 {
+		return math.NaN()
+	}
+	return skew * math.Sqrt(n*(n-1)) / (n - 2)
+}
+
+// Calculates the sample excess kurtosis, corrected for bias, from the population kurtosis
+func (d *dataBuffer) ExcessKurtosis(kurt float64) float64 {
+	n := float64(d.Count())
+	if n < 4 {
+		return math.NaN()
+	}
+	return (n - 1) / ((n - 2) * (n - 3)) * ((n+1)*(kurt-3) + 6)
+}
+
+// Calculates the least squares regression line of the values against their timestamp in seconds,
+// the intercept being the value of the line at the oldest timestamp of the buffer,
+// and the coefficient of determination of the regression
+func (d *dataBuffer) Regression() (slope, intercept, r2 float64) {
+	origin := d.window.oldest().ts
+	var n, meanX, meanY float64
+	d.eachData(func(x data) {
+		n++
+		meanX += x.ts.Sub(origin).Seconds()
+		meanY += x.value
+	})
+	meanX /= n
+	meanY /= n
+
+	var sxx, sxy, syy float64
+	d.eachData(func(x data) {
+		dx, dy := x.ts.Sub(origin).Seconds()-meanX, x.value-meanY
+		sxx += dx * dx
+		sxy += dx * dy
+		syy += dy * dy
+	})
+	if sxx == 0 {
+		// a single timestamp gives no trend
+		return math.NaN(), math.NaN(), math.NaN()
+	}
+	slope = sxy / sxx
+	intercept = meanY - slope*meanX
+	if syy == 0 {
+		// constant values are fitted exactly
+		return slope, intercept, 1
+	}
+	return slope, intercept, sxy * sxy / (sxx * syy)
+}
+
+// Calculates the derivative as the difference between the newest and the oldest values over the seconds elapsed between them
+func (d *dataBuffer) Derivative() float64 {
+	var first, last data
+	started := false
+	d.eachData(func(x data) {
+		if !started {
+			first, started = x, true
+		}
+		last = x
+	})
+	elapsed := last.ts.Sub(first.ts).Seconds()
+	if elapsed == 0 {
+		return math.NaN()
+	}
+	return (last.value - first.value) / elapsed
+}
+
+// Predicts the value of the regression line the horizon of the buffer after its newest timestamp
+func (d *dataBuffer) Predict(slope, intercept float64) float64 {
+	ahead := d.window.newest().ts.Add(d.horizon).Sub(d.window.oldest().ts).Seconds()
+	return intercept + slope*ahead
+}
+
+// Calculates the median absolute deviation from the median
+func (d *dataBuffer) MAD(median float64) float64 {
+	deviations := make([]float64, 0, d.Count())
+	d.each(func(x float64) {
+		deviations = append(deviations, math.Abs(x-median))
+	})
+	l := len(deviations)
+	if l == 0 {
+		return math.NaN()
+	}
+	sort.Float64s(deviations)
+	if l%2 == 0 {
+		return (deviations[l/2-1] + deviations[l/2]) / 2
+	}
+	return deviations[l/2]
+}
+
+// Calculates the z-score of the newest value relative to the window
+func (d *dataBuffer) ZScore(mean, stdev float64) float64 {
+	return (d.window.newest().value - mean) / stdev
+}
+
+// Calculates the robust z-score of the newest value relative to the window, the MAD being scaled
+// to estimate the standard deviation of normally distributed values. When more than half of the values
+// equal the median, the MAD is 0 and the mean absolute deviation from the median, scaled by 1.2533,
+// estimates it instead. The newest value of a constant window has a robust z-score of 0
+func (d *dataBuffer) RobustZScore(median, mad float64) float64 {
+	deviation := d.window.newest().value - median
+	scale := 1.4826 * mad
+	if mad == 0 {
+		var sum float64
+		var n int
+		d.each(func(x float64) {
+			sum += math.Abs(x - median)
+			n++
+		})
+		scale = 1.2533 * sum / float64(n)
+	}
+	if deviation == 0 && scale == 0 {
+		return 0
+	}
+	return deviation / scale
+}
+
+// Flags the newest value as an anomaly (1) when its robust z-score exceeds the threshold of the buffer (Hampel filter)
+func (d *dataBuffer) IsAnomaly(robustZScore float64) int {
+	if math.Abs(robustZScore) > d.threshold {
+		return 1
+	}
+	return 0
+}
+
+// Calculates the mean of the values once the trim fraction of the buffer is removed from each end
+func (d *dataBuffer) TrimmedMean() float64 {
+	n := d.Count()
+	k := int(float64(n) * d.trim)
+	var sum float64
+	for i := k; i < n-k; i++ {
+		sum += d.value(i)
+	}
+	return sum / float64(n-2*k)
+}
+
+// Calculates the mean of the values once the trim fraction of the buffer at each end is replaced by the nearest remaining value
+func (d *dataBuffer) WinsorizedMean() float64 {
+	n := d.Count()
+	if n == 0 {
+		return math.NaN()
+	}
+	k := int(float64(n) * d.trim)
+	sum := float64(k) * (d.value(k) + d.value(n-k-1))
+	for i := k; i < n-k; i++ {
+		sum += d.value(i)
+	}
+	return sum / float64(n)
+}
+
+// Calculates the interquartile mean, the mean of the middle half of the values, a value straddling
+// a quartile counting for its part inside the middle half
+func (d *dataBuffer) InterQuartileMean() float64 {
+	n := d.Count()
+	if n == 0 {
+		return math.NaN()
+	}
+	cut := float64(n) / 4
+	k := int(cut)
+	if k == n-k-1 {
+		return d.value(k)
+	}
+	// the values at ranks k and n-k-1 are partly cut
+	sum := (1 - (cut - float64(k))) * (d.value(k) + d.value(n-k-1))
+	for i := k + 1; i < n-k-1; i++ {
+		sum += d.value(i)
+	}
+	return sum / (float64(n) - 2*cut)
+}
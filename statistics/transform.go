@@ -0,0 +1,86 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statistics
+
+import "time"
+
+// input transforms
+const (
+	noTransform    = "none"
+	deltaTransform = "delta"
+	rateTransform  = "rate"
+)
+
+// counter holds the previous sample of a namespace whose values are transformed
+type counter struct {
+	value float64
+	ts    time.Time
+}
+
+// transform returns the increase of the counter of the named namespace since its previous sample,
+// per second for rates. It returns false when there is no previous sample to compare with,
+// when the sample is not newer than the previous one, or when the counter was reset
+func (b *dataBuffer) transform(name string, value float64, ts time.Time, mode string) (float64, bool) {
+	prev, ok := b.counters[name]
+	if ok && !ts.After(prev.ts) {
+		return 0, false
+	}
+	if b.counters == nil {
+		b.counters = make(map[string]counter)
+	}
+	b.counters[name] = counter{value: value, ts: ts}
+	if !ok {
+		return 0, false
+	}
+
+	delta := value - prev.value
+	if delta < 0 {
+		if delta, ok = wrapped(prev.value, value); !ok {
+			return 0, false
+		}
+	}
+	if mode == rateTransform {
+		return delta / ts.Sub(prev.ts).Seconds(), true
+	}
+	return delta, true
+}
+
+// wrapped returns the increase of a counter which went down from prev to value if it wrapped around
+// its 32 or 64 bits maximum, that is when it was close to the maximum and is now close to 0.
+// Otherwise the counter was reset and it returns false
+func wrapped(prev, value float64) (float64, bool) {
+	for _, max := range []float64{1 << 32, 1 << 64} {
+		if prev < max {
+			if prev >= max*3/4 && value < max/4 {
+				return max - prev + value, true
+			}
+			return 0, false
+		}
+	}
+	return 0, false
+}
+
+// prune forgets the counters whose previous sample is older than cutoff
+func (b *dataBuffer) prune(cutoff time.Time) {
+	for name, c := range b.counters {
+		if c.ts.Before(cutoff) {
+			delete(b.counters, name)
+		}
+	}
+}
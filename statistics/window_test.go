@@ -0,0 +1,200 @@
+//
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statistics
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDataBuffer(t *testing.T) {
+	Convey("Incremental window maintenance", t, func() {
+		c, err := GetConfig(newConfig())
+		So(err, ShouldBeNil)
+		c.slidingWindowLength = 50
+		buffer := newDataBuffer(c)
+
+		rnd := rand.New(rand.NewSource(1))
+		var inserted []float64
+		start := time.Now()
+		for i := 0; i < 1000; i++ {
+			// few distinct values to exercise duplicates
+			value := float64(rnd.Intn(40)) - 20
+			inserted = append(inserted, value)
+			buffer.Insert(value, start.Add(time.Duration(i)*time.Second))
+
+			window := inserted
+			if len(window) > 50 {
+				window = window[len(window)-50:]
+			}
+			sorted := append([]float64(nil), window...)
+			sort.Float64s(sorted)
+			var sum, squares float64
+			for _, v := range window {
+				sum += v
+			}
+			for _, v := range window {
+				squares += (v - sum/float64(len(window))) * (v - sum/float64(len(window)))
+			}
+
+			So(buffer.Count(), ShouldEqual, len(window))
+			So(buffer.Sum(), ShouldAlmostEqual, sum, 1e-9)
+			So(buffer.Variance(), ShouldAlmostEqual, squares/float64(len(window)), 1e-9)
+			So(buffer.Minimum(), ShouldEqual, sorted[0])
+			So(buffer.Maximum(), ShouldEqual, sorted[len(sorted)-1])
+			for k := range sorted {
+				So(buffer.value(k), ShouldEqual, sorted[k])
+			}
+			So(buffer.window.oldest().value, ShouldEqual, window[0])
+			So(buffer.window.newest().value, ShouldEqual, value)
+		}
+	})
+
+	Convey("Non finite values only affect the window they are in", t, func() {
+		c, err := GetConfig(newConfig())
+		So(err, ShouldBeNil)
+		c.slidingWindowLength = 3
+		buffer := newDataBuffer(c)
+		start := time.Now()
+		for i, value := range []float64{1, math.NaN(), math.Inf(1), 2, 3, 4} {
+			buffer.Insert(value, start.Add(time.Duration(i)*time.Second))
+		}
+		So(buffer.Sum(), ShouldEqual, 9)
+		So(buffer.Variance(), ShouldAlmostEqual, 2.0/3, 1e-9)
+		So(buffer.Minimum(), ShouldEqual, 2)
+	})
+
+	Convey("A spike leaving the window leaves no rounding error behind", t, func() {
+		c, err := GetConfig(newConfig())
+		So(err, ShouldBeNil)
+		c.slidingWindowLength = 3
+		buffer := newDataBuffer(c)
+		start := time.Now()
+		for i, value := range []float64{1, 1, 1e17, 1, 1, 1, 1, 1} {
+			buffer.Insert(value, start.Add(time.Duration(i)*time.Second))
+		}
+		So(buffer.Sum(), ShouldEqual, 3)
+		So(buffer.Mean(buffer.Sum(), buffer.Count()), ShouldEqual, 1)
+		So(buffer.Variance(), ShouldEqual, 0)
+	})
+}
+
+func TestResize(t *testing.T) {
+	Convey("Windows are resized when the window length changes", t, func() {
+		c, err := GetConfig(newConfig())
+		So(err, ShouldBeNil)
+		c.slidingWindowLength = 5
+		c.statistics = []string{sum}
+		buffer := newDataBuffer(c)
+		start := time.Now()
+		for i := 1; i <= 5; i++ {
+			buffer.Insert(float64(i), start.Add(time.Duration(i)*time.Second))
+		}
+		buffer.slidingFactorIndex = 5
+
+		Convey("Growing keeps the values", func() {
+			buffer.Resize(8)
+			So(buffer.capacity, ShouldEqual, 8)
+			So(buffer.slidingFactorIndex, ShouldEqual, 0)
+			So(buffer.Sum(), ShouldEqual, 15)
+			for i := 6; i <= 9; i++ {
+				buffer.Insert(float64(i), start.Add(time.Duration(i)*time.Second))
+			}
+			So(buffer.Count(), ShouldEqual, 8)
+			So(buffer.Sum(), ShouldEqual, 44)
+			So(buffer.Minimum(), ShouldEqual, 2)
+		})
+
+		Convey("Shrinking drops the oldest values", func() {
+			buffer.Resize(2)
+			So(buffer.capacity, ShouldEqual, 2)
+			So(buffer.slidingFactorIndex, ShouldEqual, 0)
+			So(buffer.Count(), ShouldEqual, 2)
+			So(buffer.Sum(), ShouldEqual, 9)
+			So(buffer.Minimum(), ShouldEqual, 4)
+			buffer.Insert(6, start.Add(6*time.Second))
+			So(buffer.Sum(), ShouldEqual, 11)
+		})
+
+		Convey("Feeding the buffer with another window length resizes it", func() {
+			c.slidingWindowLength = 3
+			c.slidingFactor = 2
+			mts, err := buffer.feed(plugin.Metric{
+				Data:      float64(6),
+				Namespace: plugin.NewNamespace("intel", "psutil", "load", "load1"),
+				Timestamp: start.Add(6 * time.Second),
+			}, 6, "", plugin.NewNamespace("intel", "psutil", "load", "load1"), c)
+			So(err, ShouldBeNil)
+			So(buffer.capacity, ShouldEqual, 3)
+			// the sliding factor starts over with the resized window
+			So(len(mts), ShouldEqual, 1)
+			So(mts[0].Data, ShouldEqual, 15)
+			So(buffer.slidingFactorIndex, ShouldEqual, 1)
+		})
+	})
+
+	Convey("A task having a taskId keeps the values of its window when changing it", t, func() {
+		config := newConfig()
+		config["statistics"] = count
+		config["slidingWindowLength"] = int64(5)
+		config["taskId"] = "load"
+		statisticsObj := New()
+		start := time.Now()
+
+		// process feeds values one second apart and returns the count of the last emission
+		process := func(values int) (count interface{}) {
+			for i := 0; i < values; i++ {
+				mts := []plugin.Metric{plugin.Metric{
+					Data:      float64(i),
+					Namespace: plugin.NewNamespace("intel", "psutil", "load", "load1"),
+					Timestamp: start,
+				}}
+				start = start.Add(time.Second)
+				mts, err := statisticsObj.Process(mts, config)
+				So(err, ShouldBeNil)
+				So(len(mts), ShouldEqual, 1)
+				count = mts[0].Data
+			}
+			return
+		}
+
+		So(process(7), ShouldEqual, 5)
+		config["slidingWindowLength"] = int64(8)
+		So(process(1), ShouldEqual, 6)
+		So(len(statisticsObj.buffer), ShouldEqual, 1)
+
+		Convey("Or its duration", func() {
+			config["windowDuration"] = "3s"
+			So(process(1), ShouldEqual, 3)
+			config["windowDuration"] = "0s"
+			config["slidingWindowLength"] = int64(2)
+			So(process(1), ShouldEqual, 2)
+			So(len(statisticsObj.buffer), ShouldEqual, 1)
+		})
+	})
+}